@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/echo"
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// runServeEcho runs the serve-echo subcommand and returns the exit code.
+func runServeEcho(args []string) int {
+	fs := flag.NewFlagSet("serve-echo", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	transportType := fs.String("transport", "streamable", "Transport type: sse, streamable")
+	logLevel := fs.String("log", "info", "Log level: debug, info, warn, error")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "mcp-over-socks serve-echo - run a local MCP echo server\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: mcp-over-socks serve-echo [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Requests are answered with their method and params as the result.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fmt.Fprintf(os.Stderr, "  --addr       Address to listen on (default: :8080)\n")
+		fmt.Fprintf(os.Stderr, "  --transport  Transport type: sse, streamable (default: streamable)\n")
+		fmt.Fprintf(os.Stderr, "  --log        Log level: debug, info, warn, error (default: info)\n")
+	}
+	fs.Parse(args)
+
+	logger := logging.New(logging.ParseLogLevel(*logLevel))
+
+	var handler http.Handler
+	var path string
+	switch *transportType {
+	case "sse":
+		handler, path = echo.NewSSEHandler(), "/sse"
+	case "streamable":
+		handler, path = echo.NewStreamableHandler(), "/mcp"
+	default:
+		logger.Error("Unknown transport type: %s (use sse or streamable)", *transportType)
+		return 1
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logger.Error("Failed to listen on %s: %v", *addr, err)
+		return 1
+	}
+	srv := &http.Server{Handler: handler}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	logger.Info("MCP echo server (%s) listening on http://%s%s", *transportType, ln.Addr(), path)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Echo server failed: %v", err)
+		return 1
+	}
+	return 0
+}
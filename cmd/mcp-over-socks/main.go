@@ -3,10 +3,16 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -14,6 +20,7 @@ import (
 	"github.com/iiharu/mcp-over-socks/internal/bridge"
 	"github.com/iiharu/mcp-over-socks/internal/config"
 	"github.com/iiharu/mcp-over-socks/internal/logging"
+	"github.com/iiharu/mcp-over-socks/internal/tracing"
 	"github.com/iiharu/mcp-over-socks/internal/transport"
 
 	"golang.org/x/net/proxy"
@@ -22,29 +29,198 @@ import (
 const version = "0.2.0"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve-echo" {
+		os.Exit(runServeEcho(os.Args[2:]))
+	}
+	// The replay subcommand takes the usual options, with --replay as stdin
+	replaying := len(os.Args) > 1 && os.Args[1] == "replay"
+	if replaying {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	// Define flags
 	proxyAddr := flag.String("proxy", "", "SOCKS5 proxy URL (e.g., socks5://localhost:1080)")
 	serverURL := flag.String("server", "", "Remote MCP server URL (e.g., http://remote:8080/sse)")
 	timeout := flag.Duration("timeout", 30*time.Second, "Request timeout")
-	logLevel := flag.String("log", "info", "Log level: debug, info, error")
+	logLevel := flag.String("log", "info", "Log level: debug, info, warn, error")
 	transportType := flag.String("transport", "auto", "Transport type: auto, sse, streamable")
 	showVersion := flag.Bool("version", false, "Show version and exit")
 	showHelp := flag.Bool("help", false, "Show help and exit")
+	clientPID := flag.String("client-pid", "", "Client process ID to report upstream (or \"parent\" to detect)")
+	clientName := flag.String("client-name", "", "Client name to report upstream")
+	metricsFile := flag.String("metrics-file", "", "Write a JSON snapshot of session counters to this file on exit")
+	protocolVersion := flag.String("protocol-version", "", "MCP-Protocol-Version header sent on every request (e.g., 2025-03-26)")
+	warnMessageBytes := flag.Int("warn-message-bytes", 0, "Warn about stdin messages larger than this many bytes (0 disables)")
+	allowInsecureAuth := flag.Bool("allow-insecure-http-server-with-auth", false, "Allow credential headers over plain http://")
+	errorLogFile := flag.String("error-log", "", "Append JSON-RPC error responses to this file")
+	logSyslog := flag.Bool("log-syslog", false, "Send logs to syslog instead of stderr")
+	syslogAddr := flag.String("syslog-addr", "", "Remote syslog address ([udp|tcp://]host:port)")
+	serverTLSMinVersion := flag.String("server-tls-min-version", "", "Minimum TLS version for https:// servers: 1.2, 1.3")
+	maxLineRate := flag.Float64("max-line-rate", 0, "Limit server notifications written to stdout per second (0 disables)")
+	notificationSpillBytes := flag.Int64("notification-spill-bytes", 0, "Spill notifications beyond the --max-line-rate buffer to a temp file of up to this size (0 drops them)")
+	assumeProxyLocalhost := flag.Bool("assume-proxy-localhost", false, "Suppress the warning for loopback servers with socks5h://")
+	normalizeEnvelope := flag.Bool("normalize-envelope", true, "Fix a missing or wrong jsonrpc version in server messages")
+	strict := flag.Bool("strict", false, "Replace malformed server JSON-RPC envelopes with error responses")
+	warmUp := flag.Bool("warm-up", false, "Prime the connection to the server before reading stdin")
+	ssePingEvent := flag.String("sse-ping-event", "ping", "Name of SSE keepalive events to consume (empty disables)")
+	sseEmptyData := flag.String("sse-empty-data", "drop", "Handling of SSE events with empty data (drop or forward)")
+	disableCapability := flag.String("disable-capability", "", "Client capabilities to strip from initialize (comma-separated)")
+	notificationsFD := flag.Int("notifications-fd", 0, "Write server notifications to this file descriptor instead of stdout")
+	tlsSessionCache := flag.Bool("tls-session-cache", true, "Resume TLS sessions with https:// servers")
+	framing := flag.String("framing", "line", "Stdio message framing: line, header (Content-Length)")
+	socketIOTimeout := flag.Duration("socket-io-timeout", 0, "Fail socket reads/writes that stall longer than this (0 disables)")
+	proxyCA := flag.String("proxy-ca", "", "PEM CA bundle to verify a TLS proxy (socks5s://, socks5hs://)")
+	proxyServerName := flag.String("proxy-servername", "", "TLS server name of the proxy (default: proxy host)")
+	serverCADir := flag.String("server-ca-dir", "", "Directory of *.pem/*.crt CA files to verify https:// servers")
+	warnProtocolMismatch := flag.Bool("warn-protocol-mismatch", false, "Warn if the server negotiates a different MCP protocol version than requested")
+	validateParams := flag.Bool("validate-params", false, "Validate tools/call arguments against schemas from tools/list")
+	cancelOnSignal := flag.Bool("cancel-on-signal", false, "On SIGINT/SIGTERM, send notifications/cancelled for pending requests")
+	discover := flag.Bool("discover", false, "With --transport auto, read the transport from the server's /.well-known/mcp first")
+	handleLocally := flag.String("handle-locally", "", "Comma-separated methods to answer in the bridge instead of forwarding (available: ping)")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "Export OpenTelemetry traces over OTLP/HTTP to this URL (e.g., http://localhost:4318)")
+	compat := flag.String("compat", "", "Pin the transport of an MCP spec revision: 2024-11-05 (SSE), 2025-03-26 (Streamable HTTP)")
+	onDuplicateID := flag.String("on-duplicate-id", config.DuplicateIDAllow, "Handling of requests reusing an in-flight id: allow, reject")
+	headersFile := flag.String("headers-file", "", "Load server headers from a file of \"Name: Value\" lines (# comments allowed)")
+	stdinTCP := flag.String("stdin-tcp", "", "Serve one client at a time over TCP on this address instead of stdin/stdout (e.g., 127.0.0.1:9000)")
+	logMaxString := flag.Int("log-max-string", 0, "In debug logs, abbreviate JSON string values longer than this many bytes (0 = no limit)")
+	eventWebhook := flag.String("event-webhook", "", "POST connection lifecycle events as JSON to this URL")
+	eventWebhookViaProxy := flag.Bool("event-webhook-via-proxy", false, "Send webhook events through the SOCKS proxy instead of directly")
+	noBatch := flag.Bool("no-batch", false, "Reject JSON-RPC batches (array lines) from stdin with an Invalid Request error")
+	maxEventDataBytes := flag.Int("max-event-data-bytes", 0, "Limit on the joined data of one SSE event (0 = no limit)")
+	oversizedEvent := flag.String("oversized-event", "reject", "Handling of SSE events over --max-event-data-bytes (reject, truncate or split)")
+	maxConcurrentDials := flag.Int("max-concurrent-dials", 0, "Limit on simultaneous dials through the proxy (0 = unlimited)")
+	proxyRulesFile := flag.String("proxy-rules", "", "File of 'host-pattern -> proxy URL|direct' rules choosing the proxy per server host")
+	maxClientResponseBytes := flag.Int("max-client-response-bytes", 0, "Replace responses larger than this with an error (0 = no limit)")
+	recordFile := flag.String("record", "", "Record the session's messages with timestamps to this file")
+	replayFile := flag.String("replay", "", "Recording whose requests are re-sent as stdin (replay subcommand)")
+	maxHeaderBytes := flag.Int64("max-header-bytes", 0, "Limit on server response header size (0 = net/http default of 10MB)")
+	mirrorPath := flag.String("mirror", "", "Also copy server messages to this file or Unix socket (best-effort)")
+	singleShot := flag.Bool("single-shot", false, "Forward one request from stdin, write its response and exit (non-zero on error or timeout)")
+	printConfig := flag.Bool("print-config", false, "Print the resolved configuration as JSON and exit")
+	var resolveOverrides stringSliceFlag
+	flag.Var(&resolveOverrides, "resolve", "Pin host:port to an IP address (host:port:ip, repeatable)")
+	var serverPins stringSliceFlag
+	flag.Var(&serverPins, "server-pin", "Pin the server certificate SHA-256 fingerprint (sha256:<hex|base64>, repeatable)")
+	var serverHeaders stringSliceFlag
+	flag.Var(&serverHeaders, "server-header", "Extra header for server requests (\"Name: Value\", repeatable)")
+	var methodTimeouts stringSliceFlag
+	flag.Var(&methodTimeouts, "method-timeout", "Request timeout for methods matching a name or glob, overriding --timeout (method=duration, repeatable)")
 
 	// Custom usage function
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "mcp-over-socks - MCP bridge over SOCKS5 proxy\n\n")
 		fmt.Fprintf(os.Stderr, "Uses the official MCP Go SDK (github.com/modelcontextprotocol/go-sdk)\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: mcp-over-socks [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: mcp-over-socks [options]\n")
+		fmt.Fprintf(os.Stderr, "       mcp-over-socks serve-echo [--addr :8080] [--transport sse|streamable]\n")
+		fmt.Fprintf(os.Stderr, "       mcp-over-socks replay --replay session.jsonl [options]\n\n")
 		fmt.Fprintf(os.Stderr, "Required:\n")
 		fmt.Fprintf(os.Stderr, "  --proxy      SOCKS5 proxy URL:\n")
 		fmt.Fprintf(os.Stderr, "               socks5://host:port  (local DNS resolution)\n")
 		fmt.Fprintf(os.Stderr, "               socks5h://host:port (remote DNS resolution)\n")
+		fmt.Fprintf(os.Stderr, "               socks5s://, socks5hs:// (as above, over TLS to the proxy)\n")
+		fmt.Fprintf(os.Stderr, "               The port defaults to 1080\n")
 		fmt.Fprintf(os.Stderr, "  --server     Remote MCP server URL (e.g., http://remote:8080/sse)\n\n")
 		fmt.Fprintf(os.Stderr, "Optional:\n")
 		fmt.Fprintf(os.Stderr, "  --timeout    Request timeout (default: 30s)\n")
-		fmt.Fprintf(os.Stderr, "  --log        Log level: debug, info, error (default: info)\n")
+		fmt.Fprintf(os.Stderr, "  --log        Log level: debug, info, warn, error (default: info)\n")
 		fmt.Fprintf(os.Stderr, "  --transport  Transport type: auto, sse, streamable (default: auto)\n")
+		fmt.Fprintf(os.Stderr, "  --resolve    Pin host:port to an IP, like curl (host:port:ip, repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --client-pid\n")
+		fmt.Fprintf(os.Stderr, "               Report the client PID upstream (number, or \"parent\" to detect)\n")
+		fmt.Fprintf(os.Stderr, "  --client-name\n")
+		fmt.Fprintf(os.Stderr, "               Report the client name upstream\n")
+		fmt.Fprintf(os.Stderr, "  --metrics-file\n")
+		fmt.Fprintf(os.Stderr, "               Write session counters as JSON to this file on exit\n")
+		fmt.Fprintf(os.Stderr, "  --protocol-version\n")
+		fmt.Fprintf(os.Stderr, "               MCP-Protocol-Version header to send (default: the version negotiated by initialize)\n")
+		fmt.Fprintf(os.Stderr, "  --warn-message-bytes\n")
+		fmt.Fprintf(os.Stderr, "               Warn about stdin messages over this size (hard limit: 10MB)\n")
+		fmt.Fprintf(os.Stderr, "  --server-header\n")
+		fmt.Fprintf(os.Stderr, "               Extra header for server requests (\"Name: Value\", repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --server-tls-min-version\n")
+		fmt.Fprintf(os.Stderr, "               Minimum TLS version for https:// servers: 1.2, 1.3 (default: 1.2)\n")
+		fmt.Fprintf(os.Stderr, "  --max-line-rate\n")
+		fmt.Fprintf(os.Stderr, "               Limit server notifications per second on stdout (default: unlimited)\n")
+		fmt.Fprintf(os.Stderr, "  --notification-spill-bytes\n")
+		fmt.Fprintf(os.Stderr, "               Keep throttled notifications that overflow memory in a temp file of up to this size (default: drop them)\n")
+		fmt.Fprintf(os.Stderr, "  --assume-proxy-localhost\n")
+		fmt.Fprintf(os.Stderr, "               Loopback servers with socks5h:// mean the proxy host's loopback (no warning)\n")
+		fmt.Fprintf(os.Stderr, "  --normalize-envelope\n")
+		fmt.Fprintf(os.Stderr, "               Fix a missing or wrong jsonrpc version in server messages (default: true)\n")
+		fmt.Fprintf(os.Stderr, "  --strict     Replace malformed server JSON-RPC envelopes with errors (default: normalize)\n")
+		fmt.Fprintf(os.Stderr, "  --error-log  Append JSON-RPC error responses to this file\n")
+		fmt.Fprintf(os.Stderr, "  --log-syslog\n")
+		fmt.Fprintf(os.Stderr, "               Send logs to syslog instead of stderr\n")
+		fmt.Fprintf(os.Stderr, "  --syslog-addr\n")
+		fmt.Fprintf(os.Stderr, "               Remote syslog address ([udp|tcp://]host:port, default: local)\n")
+		fmt.Fprintf(os.Stderr, "  --allow-insecure-http-server-with-auth\n")
+		fmt.Fprintf(os.Stderr, "               Allow credential headers (e.g., Authorization) over plain http://\n")
+		fmt.Fprintf(os.Stderr, "  --warm-up    Prime the proxied connection to the server before the first request\n")
+		fmt.Fprintf(os.Stderr, "  --sse-ping-event\n")
+		fmt.Fprintf(os.Stderr, "               Name of SSE keepalive events, never forwarded (default: ping)\n")
+		fmt.Fprintf(os.Stderr, "  --sse-empty-data\n")
+		fmt.Fprintf(os.Stderr, "               Handle SSE events with empty data: drop, or forward as notifications/sse_keepalive (default: drop)\n")
+		fmt.Fprintf(os.Stderr, "  --disable-capability\n")
+		fmt.Fprintf(os.Stderr, "               Strip client capabilities from initialize (e.g., sampling,roots)\n")
+		fmt.Fprintf(os.Stderr, "  --notifications-fd\n")
+		fmt.Fprintf(os.Stderr, "               Write server notifications to this fd instead of stdout (e.g., 3)\n")
+		fmt.Fprintf(os.Stderr, "  --tls-session-cache\n")
+		fmt.Fprintf(os.Stderr, "               Resume TLS sessions with https:// servers (default: true)\n")
+		fmt.Fprintf(os.Stderr, "  --framing    Stdio message framing: line, header (LSP-style Content-Length) (default: line)\n")
+		fmt.Fprintf(os.Stderr, "  --server-pin\n")
+		fmt.Fprintf(os.Stderr, "               Pin the server certificate fingerprint (sha256:<hex|base64>, repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --socket-io-timeout\n")
+		fmt.Fprintf(os.Stderr, "               Fail proxied socket reads/writes stalled this long (default: disabled)\n")
+		fmt.Fprintf(os.Stderr, "  --proxy-ca   PEM CA bundle to verify a TLS proxy (socks5s://, socks5hs://)\n")
+		fmt.Fprintf(os.Stderr, "  --proxy-servername\n")
+		fmt.Fprintf(os.Stderr, "               TLS server name to verify for the --proxy TLS proxy (default: proxy host)\n")
+		fmt.Fprintf(os.Stderr, "  --server-ca-dir\n")
+		fmt.Fprintf(os.Stderr, "               Trust only the CAs in this directory's *.pem/*.crt files for https:// servers\n")
+		fmt.Fprintf(os.Stderr, "  --warn-protocol-mismatch\n")
+		fmt.Fprintf(os.Stderr, "               Warn if the server negotiates a different protocol version\n")
+		fmt.Fprintf(os.Stderr, "  --validate-params\n")
+		fmt.Fprintf(os.Stderr, "               Reject tools/call arguments that violate the tool's input schema\n")
+		fmt.Fprintf(os.Stderr, "  --cancel-on-signal\n")
+		fmt.Fprintf(os.Stderr, "               Cancel pending requests on the server when interrupted (SIGINT/SIGTERM)\n")
+		fmt.Fprintf(os.Stderr, "  --discover   With --transport auto, use the server's /.well-known/mcp document if present\n")
+		fmt.Fprintf(os.Stderr, "  --handle-locally\n")
+		fmt.Fprintf(os.Stderr, "               Answer these methods in the bridge instead of the server (available: ping)\n")
+		fmt.Fprintf(os.Stderr, "  --otlp-endpoint\n")
+		fmt.Fprintf(os.Stderr, "               Export OpenTelemetry traces over OTLP/HTTP (e.g., http://localhost:4318)\n")
+		fmt.Fprintf(os.Stderr, "  --compat     Pin the transport of a spec revision: 2024-11-05 (SSE), 2025-03-26 (Streamable HTTP)\n")
+		fmt.Fprintf(os.Stderr, "  --on-duplicate-id\n")
+		fmt.Fprintf(os.Stderr, "               Requests reusing an in-flight id: allow, reject (default: allow)\n")
+		fmt.Fprintf(os.Stderr, "  --headers-file\n")
+		fmt.Fprintf(os.Stderr, "               Load server headers from a file of \"Name: Value\" lines; --server-header overrides\n")
+		fmt.Fprintf(os.Stderr, "  --stdin-tcp  Serve one client at a time over TCP on this address instead of stdio (e.g., 127.0.0.1:9000)\n")
+		fmt.Fprintf(os.Stderr, "  --log-max-string\n")
+		fmt.Fprintf(os.Stderr, "               Abbreviate longer JSON strings (e.g., base64 data) in debug logs (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --event-webhook\n")
+		fmt.Fprintf(os.Stderr, "               URL POST connection lifecycle events (connected, disconnected, error) as JSON\n")
+		fmt.Fprintf(os.Stderr, "  --event-webhook-via-proxy\n")
+		fmt.Fprintf(os.Stderr, "               Send webhook events through the SOCKS proxy (default: direct)\n")
+		fmt.Fprintf(os.Stderr, "  --no-batch   Reject JSON-RPC batch (array) messages from stdin with a -32600 error\n")
+		fmt.Fprintf(os.Stderr, "  --max-event-data-bytes\n")
+		fmt.Fprintf(os.Stderr, "               Limit on the data of one SSE event (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --oversized-event\n")
+		fmt.Fprintf(os.Stderr, "               Handle events over the limit: reject, truncate or split (default: reject)\n")
+		fmt.Fprintf(os.Stderr, "  --max-concurrent-dials\n")
+		fmt.Fprintf(os.Stderr, "               Limit simultaneous SOCKS handshakes; others wait (default: unlimited)\n")
+		fmt.Fprintf(os.Stderr, "  --proxy-rules\n")
+		fmt.Fprintf(os.Stderr, "               Rules file choosing the proxy (or direct) per server host (default: always --proxy)\n")
+		fmt.Fprintf(os.Stderr, "  --max-client-response-bytes\n")
+		fmt.Fprintf(os.Stderr, "               Replace larger responses with a -32000 error (default: no limit)\n")
+		fmt.Fprintf(os.Stderr, "  --record     Record stdin requests and responses with timestamps to a file\n")
+		fmt.Fprintf(os.Stderr, "  --replay     (replay subcommand) Re-send a recording's requests with their original timing\n")
+		fmt.Fprintf(os.Stderr, "  --max-header-bytes\n")
+		fmt.Fprintf(os.Stderr, "               Limit on server response header size in bytes (default: 10MB)\n")
+		fmt.Fprintf(os.Stderr, "  --mirror     Copy server messages to a file or Unix socket as well as stdout\n")
+		fmt.Fprintf(os.Stderr, "  --print-config\n")
+		fmt.Fprintf(os.Stderr, "               Print the resolved configuration (credentials redacted) as JSON and exit\n")
+		fmt.Fprintf(os.Stderr, "  --single-shot\n")
+		fmt.Fprintf(os.Stderr, "               Send one request from stdin and exit after its response (non-zero on error or timeout)\n")
+		fmt.Fprintf(os.Stderr, "  --method-timeout\n")
+		fmt.Fprintf(os.Stderr, "               Request timeout for a method or glob, e.g. tools/call=120s (repeatable)\n")
 		fmt.Fprintf(os.Stderr, "  --version    Show version and exit\n")
 		fmt.Fprintf(os.Stderr, "  --help       Show this help message\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
@@ -70,10 +246,120 @@ func main() {
 		ServerURL: *serverURL,
 		Timeout:   *timeout,
 		LogLevel:  *logLevel,
+
+		ResolveOverrides: resolveOverrides,
+		ClientName:       *clientName,
+		MetricsFile:      *metricsFile,
+		ProtocolVersion:  *protocolVersion,
+		WarnMessageBytes: *warnMessageBytes,
+
+		ServerHeaders:     serverHeaders,
+		AllowInsecureAuth: *allowInsecureAuth,
+		ErrorLogFile:      *errorLogFile,
+		LogSyslog:         *logSyslog,
+		SyslogAddr:        *syslogAddr,
+
+		ServerTLSMinVersion:    *serverTLSMinVersion,
+		MaxLineRate:            *maxLineRate,
+		NotificationSpillBytes: *notificationSpillBytes,
+
+		AssumeProxyLocalhost:   *assumeProxyLocalhost,
+		NormalizeEnvelope:      *normalizeEnvelope,
+		Strict:                 *strict,
+		WarmUp:                 *warmUp,
+		SSEPingEvent:           *ssePingEvent,
+		SSEEmptyData:           *sseEmptyData,
+		DisableCapabilities:    *disableCapability,
+		NotificationsFD:        *notificationsFD,
+		TLSSessionCache:        *tlsSessionCache,
+		Framing:                *framing,
+		ServerPins:             serverPins,
+		SocketIOTimeout:        *socketIOTimeout,
+		ProxyCAFile:            *proxyCA,
+		ProxyServerName:        *proxyServerName,
+		ServerCADir:            *serverCADir,
+		WarnProtocolMismatch:   *warnProtocolMismatch,
+		ValidateParams:         *validateParams,
+		CancelOnSignal:         *cancelOnSignal,
+		Discover:               *discover,
+		HandleLocally:          *handleLocally,
+		OTLPEndpoint:           *otlpEndpoint,
+		Compat:                 *compat,
+		OnDuplicateID:          *onDuplicateID,
+		HeadersFile:            *headersFile,
+		StdinTCP:               *stdinTCP,
+		LogMaxString:           *logMaxString,
+		EventWebhook:           *eventWebhook,
+		EventWebhookViaProxy:   *eventWebhookViaProxy,
+		NoBatch:                *noBatch,
+		MaxEventDataBytes:      *maxEventDataBytes,
+		OversizedEvent:         *oversizedEvent,
+		MaxConcurrentDials:     *maxConcurrentDials,
+		ProxyRulesFile:         *proxyRulesFile,
+		MaxClientResponseBytes: *maxClientResponseBytes,
+		RecordFile:             *recordFile,
+		MaxHeaderBytes:         *maxHeaderBytes,
+		Mirror:                 *mirrorPath,
+		MethodTimeouts:         methodTimeouts,
+		SingleShot:             *singleShot,
 	}
 
 	// Create logger
 	logger := logging.New(logging.ParseLogLevel(cfg.LogLevel))
+	if cfg.LogSyslog {
+		syslogWriter, err := logging.NewSyslogWriter(cfg.SyslogAddr, "mcp-over-socks")
+		if err != nil {
+			logger.Error("Failed to connect to syslog: %v", err)
+			os.Exit(1)
+		}
+		defer syslogWriter.Close()
+		logger = logging.NewWithWriter(logging.ParseLogLevel(cfg.LogLevel), syslogWriter)
+	}
+
+	// Resolve the client PID to report, if any
+	switch *clientPID {
+	case "":
+	case "parent":
+		cfg.ClientPID = os.Getppid()
+	default:
+		pid, err := strconv.Atoi(*clientPID)
+		if err != nil || pid <= 0 {
+			logger.Error("Configuration error: invalid --client-pid %q", *clientPID)
+			os.Exit(1)
+		}
+		cfg.ClientPID = pid
+	}
+
+	if err := cfg.LoadHeadersFile(); err != nil {
+		logger.Error("Configuration error: %v", err)
+		os.Exit(1)
+	}
+
+	var replay *bridge.Recording
+	switch {
+	case replaying && *replayFile == "":
+		logger.Error("Configuration error: the replay subcommand requires --replay")
+		os.Exit(1)
+	case !replaying && *replayFile != "":
+		logger.Error("Configuration error: --replay is only valid with the replay subcommand")
+		os.Exit(1)
+	case replaying:
+		if cfg.StdinTCP != "" || cfg.Framing == config.FramingHeader || cfg.SingleShot {
+			logger.Error("Configuration error: --replay cannot be combined with --stdin-tcp, --framing header or --single-shot")
+			os.Exit(1)
+		}
+		f, err := os.Open(*replayFile)
+		if err != nil {
+			logger.Error("Failed to open recording: %v", err)
+			os.Exit(1)
+		}
+		replay, err = bridge.ReadRecording(f)
+		f.Close()
+		if err != nil {
+			logger.Error("Failed to read recording %s: %v", *replayFile, err)
+			os.Exit(1)
+		}
+	}
 
 	// Validate config
 	if err := cfg.Validate(); err != nil {
@@ -82,21 +368,101 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Run 'mcp-over-socks --help' for usage.")
 		os.Exit(1)
 	}
+	for _, warning := range cfg.Warnings() {
+		logger.Warn("%s", warning)
+	}
 
-	// Create SOCKS dialer
-	var auth *proxy.Auth
-	if username, password, ok := cfg.ProxyAuth(); ok {
-		auth = &proxy.Auth{
-			User:     username,
-			Password: password,
+	if *printConfig {
+		selection := string(parseTransportType(*transportType, cfg.ServerURL))
+		if cfg.Compat != "" {
+			tType, _ := bridge.TransportForSpec(cfg.Compat) // already validated
+			selection = string(tType)
+		} else if cfg.Discover && strings.EqualFold(*transportType, "auto") {
+			selection = "discovered at startup, else " + selection
 		}
+		data, err := cfg.Dump(map[string]string{"transport": selection})
+		if err != nil {
+			logger.Error("Failed to dump configuration: %v", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		os.Exit(0)
 	}
 
-	socksDialer, err := transport.NewSOCKSDialer(cfg.ProxyHost(), auth, cfg.IsRemoteDNS())
+	// Create SOCKS dialer. Rule proxies share its dial limit.
+	dialLimiter := transport.NewDialLimiter(cfg.MaxConcurrentDials)
+	socksDialer, err := newProxyDialer(cfg, cfg.ProxyAddr, dialLimiter)
 	if err != nil {
 		logger.Error("Failed to create SOCKS dialer: %v", err)
 		os.Exit(1)
 	}
+	if cfg.IsProxyTLS() {
+		logger.Debug("Using TLS to the SOCKS proxy")
+	}
+	if overrides, err := cfg.ResolveMap(); err == nil {
+		for hostPort, ip := range overrides {
+			logger.Debug("Resolve override: %s -> %s", hostPort, ip)
+		}
+	}
+	if cfg.MaxConcurrentDials > 0 {
+		logger.Debug("Limiting concurrent proxy dials to %d", cfg.MaxConcurrentDials)
+	}
+
+	rules, err := cfg.LoadProxyRules()
+	if err != nil {
+		logger.Error("Configuration error: %v", err)
+		os.Exit(1)
+	}
+	if len(rules) > 0 {
+		routes := make([]transport.ProxyRoute, len(rules))
+		for i, rule := range rules {
+			routes[i].Pattern = rule.Pattern
+			if rule.ProxyAddr == "" {
+				logger.Debug("Proxy rule: %s -> direct", rule.Pattern)
+				continue
+			}
+			routes[i].Dialer, err = newProxyDialer(cfg, rule.ProxyAddr, dialLimiter)
+			if err != nil {
+				logger.Error("Failed to create SOCKS dialer for proxy rule %s: %v", rule.Pattern, err)
+				os.Exit(1)
+			}
+			ruleCfg := config.Config{ProxyAddr: rule.ProxyAddr}
+			logger.Debug("Proxy rule: %s -> %s://%s", rule.Pattern, ruleCfg.ProxyScheme(), ruleCfg.ProxyHost())
+		}
+		ruleDialer := transport.NewRuleBasedDialer(routes, socksDialer)
+		// Direct routes get the same dial settings as proxied ones
+		if overrides, err := cfg.ResolveMap(); err == nil && len(overrides) > 0 {
+			ruleDialer.SetResolveOverrides(overrides)
+		}
+		ruleDialer.SetSocketIOTimeout(cfg.SocketIOTimeout)
+		ruleDialer.SetDialLimiter(dialLimiter)
+		socksDialer.SetProxyRules(ruleDialer)
+	}
+
+	tlsConfig := &tls.Config{}
+	if minVersion, _ := cfg.TLSMinVersion(); minVersion != 0 {
+		tlsConfig.MinVersion = minVersion
+		logger.Debug("Minimum server TLS version: %s", cfg.ServerTLSMinVersion)
+	}
+	if cfg.ServerCADir != "" {
+		tlsConfig.RootCAs, err = transport.LoadCertPoolDir(cfg.ServerCADir)
+		if err != nil {
+			logger.Error("Failed to load server CAs: %v", err)
+			os.Exit(1)
+		}
+		logger.Debug("Trusting server CAs from %s", cfg.ServerCADir)
+	}
+	if pins, _ := cfg.ServerPinHashes(); len(pins) > 0 {
+		tlsConfig.VerifyConnection = transport.VerifyCertificatePins(pins)
+		logger.Debug("Pinning server certificate to %d fingerprint(s)", len(pins))
+	}
+	socksDialer.SetTLSConfig(tlsConfig)
+	if cfg.MaxHeaderBytes > 0 {
+		socksDialer.SetMaxResponseHeaderBytes(cfg.MaxHeaderBytes)
+	}
+	if cfg.TLSSessionCache {
+		socksDialer.SetTLSSessionCache(tls.NewLRUClientSessionCache(0))
+	}
 
 	if cfg.IsRemoteDNS() {
 		logger.Debug("Using remote DNS resolution (socks5h://)")
@@ -104,15 +470,160 @@ func main() {
 		logger.Debug("Using local DNS resolution (socks5://)")
 	}
 
+	// Create HTTP client with SOCKS proxy
+	httpClient := socksDialer.HTTPClient(cfg.Timeout)
+	headers, _ := cfg.ServerHeaderMap() // already validated
+	for name, value := range cfg.ClientHeaders() {
+		headers[name] = value
+	}
+	if len(headers) > 0 {
+		for name, value := range headers {
+			if config.IsSensitiveHeader(name) {
+				value = "[REDACTED]"
+			}
+			logger.Debug("Server header: %s: %s", name, value)
+		}
+		httpClient.Transport = transport.NewHeaderTransport(httpClient.Transport, headers)
+	}
+
 	// Determine transport type
-	tType := parseTransportType(*transportType, cfg.ServerURL)
+	var tType bridge.TransportType
+	if cfg.Compat != "" {
+		tType, _ = bridge.TransportForSpec(cfg.Compat) // already validated
+		if !strings.EqualFold(*transportType, "auto") && parseTransportType(*transportType, cfg.ServerURL) != tType {
+			logger.Error("--transport %s conflicts with --compat %s", *transportType, cfg.Compat)
+			os.Exit(1)
+		}
+		logger.Debug("Pinned to the MCP %s transport", cfg.Compat)
+	} else if cfg.Discover && strings.EqualFold(*transportType, "auto") {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+		d, err := bridge.Discover(ctx, httpClient, cfg.ServerURL)
+		cancel()
+		switch {
+		case err != nil:
+			logger.Warn("Transport discovery failed, falling back to auto-detection: %v", err)
+		case d == nil:
+			logger.Debug("No /.well-known/mcp document, falling back to auto-detection")
+		default:
+			logger.Info("Discovered %s transport at %s", d.Transport, d.Endpoint)
+			tType = d.Transport
+			cfg.ServerURL = d.Endpoint
+		}
+	}
+	if tType == "" {
+		tType = parseTransportType(*transportType, cfg.ServerURL)
+	}
 	logger.Info("Using %s transport", tType)
 
-	// Create HTTP client with SOCKS proxy
-	httpClient := socksDialer.HTTPClient(cfg.Timeout)
+	var shutdownTracing func(context.Context) error
+	if cfg.OTLPEndpoint != "" {
+		shutdownTracing, err = tracing.Setup(context.Background(), cfg.OTLPEndpoint, version)
+		if err != nil {
+			logger.Error("Failed to set up tracing: %v", err)
+			os.Exit(1)
+		}
+		httpClient.Transport = tracing.NewTransport(httpClient.Transport)
+		logger.Debug("Exporting traces to %s", cfg.OTLPEndpoint)
+	}
 
-	// Create bridge
-	b := bridge.New(cfg, httpClient, logger, tType)
+	for _, method := range cfg.LocallyHandledMethods() {
+		if !slices.Contains(bridge.BuiltinLocalMethods(), method) {
+			logger.Error("Invalid --handle-locally: no built-in handler for method %q (available: %v)", method, bridge.BuiltinLocalMethods())
+			os.Exit(1)
+		}
+		logger.Debug("Answering %s locally", method)
+	}
+
+	var errorLog *os.File
+	if cfg.ErrorLogFile != "" {
+		// Error responses may quote request data and server internals
+		errorLog, err = os.OpenFile(cfg.ErrorLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			logger.Error("Failed to open error log: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	var notifications io.Writer
+	if cfg.NotificationsFD > 0 {
+		f := os.NewFile(uintptr(cfg.NotificationsFD), "notifications")
+		// NewFile accepts any number; Stat fails if the fd is not open
+		if _, err := f.Stat(); err != nil {
+			logger.Error("Invalid notifications file descriptor %d: %v", cfg.NotificationsFD, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		notifications = f
+	}
+
+	var webhook *bridge.WebhookNotifier
+	if cfg.EventWebhook != "" {
+		webhookClient := &http.Client{Timeout: cfg.Timeout}
+		if cfg.EventWebhookViaProxy {
+			webhookClient = socksDialer.HTTPClient(cfg.Timeout)
+		}
+		webhook = bridge.NewWebhookNotifier(cfg.EventWebhook, webhookClient, logger)
+		logger.Debug("Sending lifecycle events to webhook")
+	}
+
+	var mirror *bridge.Mirror
+	var mirrorSink io.Closer
+	if cfg.Mirror != "" {
+		sink, err := bridge.OpenMirrorSink(cfg.Mirror)
+		if err != nil {
+			logger.Error("Failed to open mirror: %v", err)
+			os.Exit(1)
+		}
+		mirrorSink = sink
+		mirror = bridge.NewMirror(sink, logger)
+		logger.Debug("Mirroring server messages to %s", cfg.Mirror)
+	}
+
+	var recorder *bridge.Recorder
+	var recording *os.File
+	if cfg.RecordFile != "" {
+		// Recordings hold full messages, which may include secrets
+		recording, err = os.OpenFile(cfg.RecordFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			logger.Error("Failed to create recording: %v", err)
+			os.Exit(1)
+		}
+		recorder, err = bridge.NewRecorder(recording, cfg.ServerURL, tType)
+		if err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+		logger.Debug("Recording session to %s", cfg.RecordFile)
+	}
+
+	// runBridge runs a bridge session over the given stdin and stdout. The
+	// stats of the last session are written to --metrics-file on shutdown.
+	var lastStats *bridge.Stats
+	runBridge := func(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+		b := bridge.NewWithIO(cfg, httpClient, logger, tType, stdin, stdout)
+		for _, method := range cfg.LocallyHandledMethods() {
+			b.UseBuiltinHandler(method) // already validated
+		}
+		if errorLog != nil {
+			b.SetErrorLog(errorLog)
+		}
+		if notifications != nil {
+			b.SetNotificationOutput(notifications)
+		}
+		if webhook != nil {
+			b.SetEventHandler(webhook.Notify)
+		}
+		if recorder != nil {
+			b.SetRecorder(recorder)
+		}
+		if mirror != nil {
+			b.SetMirror(mirror)
+		}
+
+		err := b.Run(ctx)
+		lastStats = b.Stats()
+		return err
+	}
 
 	// Setup context with signal handling
 	ctx, cancel := context.WithCancel(context.Background())
@@ -132,7 +643,27 @@ func main() {
 	logger.Debug("Proxy: %s", cfg.ProxyAddr)
 	logger.Debug("Server: %s", cfg.ServerURL)
 
-	if err := b.Run(ctx); err != nil {
+	if cfg.StdinTCP != "" {
+		var ln net.Listener
+		ln, err = net.Listen("tcp", cfg.StdinTCP)
+		if err != nil {
+			logger.Error("Failed to listen on %s: %v", cfg.StdinTCP, err)
+			os.Exit(1)
+		}
+		err = bridge.ServeListener(ctx, ln, logger, func(ctx context.Context, conn net.Conn) error {
+			return runBridge(ctx, conn, conn)
+		})
+	} else if replay != nil {
+		logger.Info("Replaying %d recorded events from %s", len(replay.Events), *replayFile)
+		err = runBridge(ctx, bridge.NewReplayReader(replay), os.Stdout)
+	} else {
+		err = runBridge(ctx, os.Stdin, os.Stdout)
+	}
+
+	bridge.Shutdown(shutdownSteps(cfg, logger, lastStats, recorder, recording, mirror, mirrorSink, webhook, errorLog, shutdownTracing),
+		5*time.Second, logger)
+
+	if err != nil {
 		logger.Error("Bridge error: %v", err)
 		// Print user-friendly error message
 		friendlyMsg := bridge.FormatUserFriendlyError(err)
@@ -144,6 +675,121 @@ func main() {
 	}
 }
 
+// shutdownSteps returns the steps run once the bridge has stopped, in
+// order: the recording and metrics first, since they describe the session,
+// then the sinks fed during it, and finally traces, which cover the steps
+// before them. Components that are not in use are skipped.
+func shutdownSteps(cfg *config.Config, logger *logging.Logger, stats *bridge.Stats, recorder *bridge.Recorder, recording *os.File,
+	mirror *bridge.Mirror, mirrorSink io.Closer, webhook *bridge.WebhookNotifier, errorLog *os.File,
+	shutdownTracing func(context.Context) error) []bridge.ShutdownStep {
+	var steps []bridge.ShutdownStep
+	if recorder != nil {
+		steps = append(steps, bridge.ShutdownStep{Name: "write recording", Run: func(ctx context.Context) error {
+			err := recorder.Err()
+			if err == nil {
+				err = recording.Sync()
+			}
+			if cerr := recording.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}})
+	}
+	if cfg.MetricsFile != "" && stats != nil {
+		steps = append(steps, bridge.ShutdownStep{Name: "write metrics file", Run: func(ctx context.Context) error {
+			if err := stats.WriteFile(cfg.MetricsFile); err != nil {
+				return err
+			}
+			logger.Debug("Metrics written to %s", cfg.MetricsFile)
+			return nil
+		}})
+	}
+	if mirror != nil {
+		steps = append(steps, bridge.ShutdownStep{Name: "write pending messages to the mirror", Run: func(ctx context.Context) error {
+			err := mirror.Close(ctx)
+			// Closing the sink also ends a write stalled on it
+			mirrorSink.Close()
+			return err
+		}})
+	}
+	if webhook != nil {
+		steps = append(steps, bridge.ShutdownStep{Name: "deliver pending webhook events", Run: webhook.Close})
+	}
+	if errorLog != nil {
+		steps = append(steps, bridge.ShutdownStep{Name: "close error log", Run: func(ctx context.Context) error {
+			if err := errorLog.Sync(); err != nil {
+				errorLog.Close()
+				return err
+			}
+			return errorLog.Close()
+		}})
+	}
+	if shutdownTracing != nil {
+		steps = append(steps, bridge.ShutdownStep{Name: "flush traces", Run: shutdownTracing})
+	}
+	return steps
+}
+
+// newProxyDialer creates a SOCKS dialer for the proxy at proxyAddr (a
+// socks5[h][s]:// URL), with the dial settings from cfg and dials bounded
+// by limiter. --proxy-servername names the --proxy proxy only, so other
+// TLS proxies are verified against their own host; --proxy-ca applies to
+// all of them.
+func newProxyDialer(cfg *config.Config, proxyAddr string, limiter *transport.DialLimiter) (*transport.SOCKSDialer, error) {
+	pc := *cfg
+	pc.ProxyAddr = proxyAddr
+	if proxyAddr != cfg.ProxyAddr {
+		pc.ProxyServerName = ""
+	}
+
+	var auth *proxy.Auth
+	if username, password, ok := pc.ProxyAuth(); ok {
+		auth = &proxy.Auth{
+			User:     username,
+			Password: password,
+		}
+	}
+
+	var socksDialer *transport.SOCKSDialer
+	var err error
+	if pc.IsProxyTLS() {
+		proxyTLS := &tls.Config{ServerName: pc.ProxyServerName}
+		if pc.ProxyCAFile != "" {
+			proxyTLS.RootCAs, err = transport.LoadCertPool(pc.ProxyCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load proxy CA: %w", err)
+			}
+		}
+		socksDialer, err = transport.NewSOCKSDialerOverTLS(pc.ProxyHost(), auth, pc.IsRemoteDNS(), proxyTLS)
+	} else {
+		socksDialer, err = transport.NewSOCKSDialer(pc.ProxyHost(), auth, pc.IsRemoteDNS())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if overrides, err := pc.ResolveMap(); err == nil && len(overrides) > 0 {
+		socksDialer.SetResolveOverrides(overrides)
+	}
+	if pc.SocketIOTimeout > 0 {
+		socksDialer.SetSocketIOTimeout(pc.SocketIOTimeout)
+	}
+	socksDialer.SetDialLimiter(limiter)
+	return socksDialer, nil
+}
+
+// stringSliceFlag is a flag.Value that collects repeated flag occurrences.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 // parseTransportType parses the transport type from string, with auto-detection based on URL.
 func parseTransportType(s string, serverURL string) bridge.TransportType {
 	switch strings.ToLower(s) {
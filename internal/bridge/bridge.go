@@ -3,19 +3,26 @@ package bridge
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/iiharu/mcp-over-socks/internal/config"
 	"github.com/iiharu/mcp-over-socks/internal/logging"
+	"github.com/iiharu/mcp-over-socks/internal/tracing"
+	"github.com/iiharu/mcp-over-socks/internal/transport"
 	"github.com/modelcontextprotocol/go-sdk/jsonrpc"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // TransportType represents the type of transport to use.
@@ -28,15 +35,42 @@ const (
 	TransportStreamable TransportType = "streamable"
 )
 
+// MaxMessageSize is the hard limit on the size of a single stdin message.
+const MaxMessageSize = 10 * 1024 * 1024 // 10MB
+
+// protocolVersionHeader is the HTTP header used for MCP protocol version negotiation.
+const protocolVersionHeader = "MCP-Protocol-Version"
+
+// cancelTimeout bounds sending cancellations for pending requests at shutdown.
+const cancelTimeout = 2 * time.Second
+
 // Bridge connects stdio to a remote MCP server using the official MCP SDK.
 type Bridge struct {
 	config        *config.Config
 	logger        *logging.Logger
 	httpClient    *http.Client
 	transportType TransportType
-
-	stdin  io.Reader
-	stdout io.Writer
+	stats         Stats
+	inflight      *inflightTracker
+	errorLog      *errorLog
+
+	stdin        io.Reader
+	stdout       io.Writer
+	stdoutMu     sync.Mutex
+	stdoutErr    error         // First stdout write error; no writes are attempted after it
+	stdoutBroken chan struct{} // Closed once stdoutErr is set
+
+	notifications   io.Writer // Destination for server notifications, nil for stdout
+	notificationsMu sync.Mutex
+
+	requestedVersion  atomic.Value // Protocol version requested by the client's initialize (string)
+	negotiatedVersion atomic.Value // Protocol version returned by the server's initialize (string)
+	toolSchemas       *toolSchemas
+	localHandlers     map[string]LocalHandler // Methods answered without the server
+	onEvent           func(Event)             // Connection lifecycle event handler
+	recorder          *Recorder               // Session recorder, nil if not recording
+	mirror            *Mirror                 // Copy of server messages, nil for none
+	shot              *singleShot             // The request in single-shot mode, nil otherwise
 }
 
 // New creates a new Bridge.
@@ -46,8 +80,11 @@ func New(cfg *config.Config, httpClient *http.Client, logger *logging.Logger, tr
 		logger:        logger,
 		httpClient:    httpClient,
 		transportType: transportType,
+		inflight:      newInflightTracker(),
+		toolSchemas:   newToolSchemas(),
 		stdin:         os.Stdin,
 		stdout:        os.Stdout,
+		stdoutBroken:  make(chan struct{}),
 	}
 }
 
@@ -58,29 +95,78 @@ func NewWithIO(cfg *config.Config, httpClient *http.Client, logger *logging.Logg
 		logger:        logger,
 		httpClient:    httpClient,
 		transportType: transportType,
+		inflight:      newInflightTracker(),
+		toolSchemas:   newToolSchemas(),
 		stdin:         stdin,
 		stdout:        stdout,
+		stdoutBroken:  make(chan struct{}),
 	}
 }
 
-// Run starts the bridge and blocks until the context is cancelled or an error occurs.
-func (b *Bridge) Run(ctx context.Context) error {
+// SetErrorLog records every JSON-RPC error response sent to the client,
+// whether from the server or synthesized by the bridge, to w.
+func (b *Bridge) SetErrorLog(w io.Writer) {
+	b.errorLog = &errorLog{w: w}
+}
+
+// SetNotificationOutput routes server notifications to w instead of stdout,
+// leaving stdout to responses and server requests.
+func (b *Bridge) SetNotificationOutput(w io.Writer) {
+	b.notifications = w
+}
+
+// Stats returns the bridge's session counters.
+func (b *Bridge) Stats() *Stats {
+	return &b.stats
+}
+
+// Run starts the bridge and blocks until the context is cancelled, an error
+// occurs, stdin is closed (once pending responses are delivered), or the
+// server connection and stdin have both closed.
+func (b *Bridge) Run(ctx context.Context) (runErr error) {
+	b.stats.start()
+	defer b.stats.stop()
+	if b.config.SingleShot {
+		b.shot = newSingleShot()
+	}
+
 	b.logger.Info("Connecting to MCP server: %s", b.config.ServerURL)
 	b.logger.Debug("Using proxy: %s", b.config.ProxyAddr)
 	b.logger.Debug("Transport type: %s", b.transportType)
 
+	httpClient := withEnvelopeCheck(withDropCheck(b.httpClient), b.logger, b.config.NormalizeEnvelope, b.config.Strict, b.config.SSEPingEvent,
+		b.config.SSEEmptyData, b.config.MaxEventDataBytes, b.config.OversizedEvent)
+	if strings.HasPrefix(b.config.ServerURL, "https://") {
+		httpClient = withTLSDebug(httpClient, b.logger)
+	}
+
+	// Send the configured protocol version on every request, or else the
+	// version negotiated by initialize on every request after it
+	if b.config.ProtocolVersion != "" {
+		b.logger.Debug("Using MCP protocol version header: %s", b.config.ProtocolVersion)
+		httpClient = withHeaders(httpClient, map[string]string{
+			protocolVersionHeader: b.config.ProtocolVersion,
+		})
+	} else {
+		httpClient = withNegotiatedVersion(httpClient, &b.negotiatedVersion)
+	}
+
+	if timeouts, _ := b.config.MethodTimeoutList(); len(timeouts) > 0 {
+		httpClient = withMethodTimeouts(httpClient, timeouts)
+	}
+
 	// Create the appropriate transport
 	var transport mcp.Transport
 	switch b.transportType {
 	case TransportSSE:
 		transport = &mcp.SSEClientTransport{
 			Endpoint:   b.config.ServerURL,
-			HTTPClient: b.httpClient,
+			HTTPClient: httpClient,
 		}
 	case TransportStreamable:
 		transport = &mcp.StreamableClientTransport{
 			Endpoint:   b.config.ServerURL,
-			HTTPClient: b.httpClient,
+			HTTPClient: httpClient,
 		}
 	default:
 		return fmt.Errorf("unknown transport type: %s", b.transportType)
@@ -90,20 +176,35 @@ func (b *Bridge) Run(ctx context.Context) error {
 	conn, err := transport.Connect(ctx)
 	if err != nil {
 		b.logger.Error("Connection failed: %v", err)
+		if strings.Contains(err.Error(), "protocol version not supported") && b.config.ServerTLSMinVersion != "" {
+			b.logger.Error("The server does not support TLS %s or later, required by --server-tls-min-version", b.config.ServerTLSMinVersion)
+		}
+		b.emit(EventError, err)
 		return WrapError(ErrServerConnection, err.Error())
 	}
 	defer func() {
 		b.logger.Info("Disconnecting from MCP server")
 		conn.Close()
 		b.logger.Debug("Connection closed")
+		b.emit(EventDisconnected, runErr)
 	}()
 
 	b.logger.Info("Connected to MCP server successfully")
+	b.emit(EventConnected, nil)
+
+	if b.config.WarmUp {
+		b.warmUp(ctx, httpClient)
+	}
 
 	// Create channels for coordinating goroutines
-	errCh := make(chan error, 2)
+	errCh := make(chan error, 3)
 	var wg sync.WaitGroup
 
+	// Stdin EOF shuts the whole bridge down
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// Start stdin reader goroutine
 	wg.Add(1)
 	go func() {
@@ -113,9 +214,36 @@ func (b *Bridge) Run(ctx context.Context) error {
 			case errCh <- fmt.Errorf("stdin reader error: %w", err):
 			default:
 			}
+			return
+		}
+		if ctx.Err() == nil {
+			if b.shot != nil {
+				// Run ends once the single-shot response is written
+				if !b.shot.started() {
+					select {
+					case errCh <- errNoSingleShotRequest:
+					default:
+					}
+				}
+				return
+			}
+			b.drainAfterStdinEOF(ctx)
+			cancel()
 		}
 	}()
 
+	if b.shot != nil {
+		go func() {
+			err := b.awaitSingleShot(ctx)
+			if ctx.Err() == nil {
+				select {
+				case errCh <- err:
+				default:
+				}
+			}
+		}()
+	}
+
 	// Start response handler goroutine
 	wg.Add(1)
 	go func() {
@@ -128,23 +256,138 @@ func (b *Bridge) Run(ctx context.Context) error {
 		}
 	}()
 
-	// Wait for context cancellation or error
+	// Signal when both goroutines have exited normally
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Wait for context cancellation, an error, or both goroutines to finish
 	select {
 	case <-ctx.Done():
 		b.logger.Info("Shutting down bridge")
+		if parent.Err() != nil && b.config.CancelOnSignal {
+			b.cancelPending(conn)
+		}
 		return nil
 	case err := <-errCh:
 		return err
+	case <-b.stdoutBroken:
+		b.stdoutMu.Lock()
+		err := b.stdoutErr
+		b.stdoutMu.Unlock()
+		b.logger.Error("Shutting down bridge: failed to write to stdout: %v", err)
+		return fmt.Errorf("failed to write to stdout: %w", err)
+	case <-done:
+		// An error may have been sent just before the goroutines exited
+		select {
+		case err := <-errCh:
+			return err
+		default:
+		}
+		b.logger.Info("Stdin and server connection closed, shutting down bridge")
+		return nil
+	}
+}
+
+// cancelPending sends notifications/cancelled for every in-flight request,
+// so the server can abort work that nobody will wait for.
+func (b *Bridge) cancelPending(conn mcp.Connection) {
+	pending := b.inflight.drain()
+	if len(pending) == 0 {
+		return
+	}
+	b.logger.Info("Cancelling %d pending request(s) on the server", len(pending))
+
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+	for _, req := range pending {
+		params, _ := json.Marshal(map[string]interface{}{
+			"requestId": req.ID.Raw(),
+			"reason":    "client bridge shutting down",
+		})
+		notification := &jsonrpc.Request{Method: "notifications/cancelled", Params: params}
+		if err := conn.Write(ctx, notification); err != nil {
+			b.logger.Warn("Failed to cancel request %v (%s): %v", req.ID.Raw(), req.Method, err)
+		}
+		tracing.EndSpan(req.Span, errors.New("cancelled: client bridge shutting down"))
+	}
+}
+
+// drainAfterStdinEOF waits, up to the longest request timeout, for responses to
+// requests still in flight when the client closed stdin, so that piped
+// usage (e.g. `echo request | mcp-over-socks ...`) still gets its answers.
+func (b *Bridge) drainAfterStdinEOF(ctx context.Context) {
+	pending := b.inflight.len()
+	if pending == 0 {
+		b.logger.Info("Stdin closed, shutting down bridge")
+		return
+	}
+	b.logger.Info("Stdin closed, waiting for %d pending response(s) before shutting down", pending)
+	wait := b.config.Timeout
+	timeouts, _ := b.config.MethodTimeoutList()
+	for _, mt := range timeouts {
+		wait = max(wait, mt.Timeout)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	if err := b.inflight.wait(waitCtx); err != nil && ctx.Err() == nil {
+		b.logger.Warn("Gave up waiting for %d pending response(s)", b.inflight.len())
+	}
+}
+
+// warmUp sends an OPTIONS request to the server so that the SOCKS tunnel and
+// a keep-alive connection are established before the first client request.
+// Failures are logged and otherwise ignored.
+func (b *Bridge) warmUp(ctx context.Context, client *http.Client) {
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodOptions, b.config.ServerURL, nil)
+	if err != nil {
+		b.logger.Warn("Connection warm-up failed: %v", err)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		b.logger.Warn("Connection warm-up failed: %v", err)
+		return
+	}
+	// Drain the body so the connection returns to the pool
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	b.logger.Debug("Connection warmed up in %v (HTTP %d)", time.Since(start), resp.StatusCode)
+}
+
+// rewriteInitialize records the protocol version requested by the client's
+// initialize request and applies the configured capability policy to it.
+func (b *Bridge) rewriteInitialize(req *jsonrpc.Request) {
+	b.requestedVersion.Store(protocolVersionOf(req.Params))
+
+	disabled := b.config.DisabledCapabilities()
+	if len(disabled) == 0 || len(req.Params) == 0 {
+		return
+	}
+	params, removed, err := stripCapabilities(req.Params, disabled)
+	if err != nil {
+		b.logger.Warn("Failed to rewrite initialize params, forwarding unchanged: %v", err)
+		return
+	}
+	if len(removed) > 0 {
+		b.logger.Info("Removed client capabilities from initialize: %s", strings.Join(removed, ", "))
+		req.Params = params
 	}
 }
 
 // readStdin reads JSON-RPC requests from stdin and forwards them to the server.
+// Messages are written one at a time in stdin order, so a notification never
+// overtakes a preceding request on the wire (e.g. notifications/initialized
+// always follows initialize); only waiting for responses is concurrent.
 func (b *Bridge) readStdin(ctx context.Context, conn mcp.Connection) error {
 	scanner := bufio.NewScanner(b.stdin)
 	// Increase buffer size for large JSON messages
-	const maxScannerSize = 10 * 1024 * 1024 // 10MB
 	buf := make([]byte, 64*1024)
-	scanner.Buffer(buf, maxScannerSize)
+	scanner.Buffer(buf, MaxMessageSize)
+	scanner.Split(splitFunc(b.config.Framing))
 
 	for scanner.Scan() {
 		select {
@@ -152,33 +395,131 @@ func (b *Bridge) readStdin(ctx context.Context, conn mcp.Connection) error {
 			return nil
 		default:
 		}
+		if b.shot != nil && b.shot.started() {
+			b.logger.Debug("Ignoring stdin after the single-shot request")
+			return nil
+		}
 
 		line := scanner.Bytes()
 		if len(line) == 0 {
 			continue
 		}
 
+		if warnAt := b.config.WarnMessageBytes; warnAt > 0 && len(line) > warnAt {
+			b.logger.Warn("Large message from stdin (%d bytes, warning threshold %d bytes); messages over %d bytes will be rejected",
+				len(line), warnAt, MaxMessageSize)
+		}
+
 		// Validate JSON
 		if !json.Valid(line) {
 			b.logger.Error("Invalid JSON received from stdin")
+			b.stats.recordError()
 			continue
 		}
 
-		b.logger.Debug("Sending request to server: %s", string(line))
+		if b.recorder != nil {
+			b.recorder.record(RecordRequest, line)
+		}
+
+		if b.config.NoBatch && isBatch(line) {
+			b.logger.Warn("Rejecting JSON-RPC batch from stdin (--no-batch)")
+			b.stats.recordError()
+			b.writeErrorResponse(nil, "", CodeInvalidRequest, "batch requests are not accepted")
+			continue
+		}
+
+		b.logger.Debug("Sending request to server: %s", logJSON{line, b.config.LogMaxString})
 
 		// Parse the message using the SDK's jsonrpc package
 		msg, err := jsonrpc.DecodeMessage(line)
 		if err != nil {
 			b.logger.Error("Failed to parse JSON-RPC message: %v", err)
+			b.stats.recordError()
 			continue
 		}
 
+		if req, ok := msg.(*jsonrpc.Request); ok && req.IsCall() && b.shot != nil {
+			b.shot.start(req.ID.Raw(), req.Method, b.requestTimeout(req.Method))
+		}
+
+		if req, ok := msg.(*jsonrpc.Request); ok && req.Method == "initialize" {
+			b.rewriteInitialize(req)
+		}
+
+		if req, ok := msg.(*jsonrpc.Request); ok && req.IsCall() && req.Method == "tools/call" && b.config.ValidateParams {
+			if err := b.toolSchemas.validate(req.Params); err != nil {
+				b.logger.Warn("Rejecting tools/call with invalid params: %v", err)
+				b.stats.recordError()
+				b.writeErrorResponse(req.ID.Raw(), req.Method, CodeInvalidParams, "Invalid params: "+err.Error())
+				continue
+			}
+		}
+
+		if req, ok := msg.(*jsonrpc.Request); ok && req.IsCall() && b.config.OnDuplicateID == config.DuplicateIDReject {
+			if _, pending := b.inflight.get(req.ID); pending {
+				b.logger.Warn("Rejecting %s: id %v is already in flight", req.Method, req.ID.Raw())
+				b.stats.recordError()
+				b.writeErrorResponse(req.ID.Raw(), req.Method, CodeInvalidRequest, "duplicate id in flight")
+				continue
+			}
+		}
+
+		if req, ok := msg.(*jsonrpc.Request); ok && req.IsCall() {
+			if h, ok := b.localHandlers[req.Method]; ok {
+				b.logger.Debug("Answering %s locally", req.Method)
+				b.answerLocally(ctx, req, h)
+				continue
+			}
+		}
+
+		// Track calls before writing, since the response may arrive before
+		// Write returns
+		req, isRequest := msg.(*jsonrpc.Request)
+		isCall := isRequest && req.IsCall()
+		writeCtx, span := ctx, trace.SpanFromContext(ctx)
+		if isRequest {
+			writeCtx, span = tracing.StartRequest(ctx, req.Method)
+			writeCtx = withRequestMethod(writeCtx, req.Method)
+		}
+		if isCall {
+			b.inflight.add(req, span)
+		}
+		writeCtx, failure := withConnFailure(writeCtx)
+
 		// Write to the connection
-		if err := conn.Write(ctx, msg); err != nil {
-			b.logger.Error("Failed to send request: %v", err)
+		if err := conn.Write(writeCtx, msg); err != nil {
+			tracing.EndSpan(span, err)
+			if ctx.Err() != nil {
+				// Cancelled by shutdown, not a failure of the request itself.
+				// The call stays pending, so that Run can still cancel it on
+				// the server
+				b.logger.Debug("Request aborted by shutdown: %v", err)
+				return nil
+			}
+			if isCall {
+				b.inflight.remove(req.ID)
+			}
+			if isTimeout(err) {
+				err = WrapError(ErrTimeout, err.Error())
+				b.logger.Error("Failed to send request: %v", err)
+				b.logger.Error("%s", FormatUserFriendlyError(err))
+			} else if cause := failure.get(); cause != nil {
+				err = cause
+				b.logger.Error("Failed to send request: %v", err)
+				b.logger.Error("%s", FormatUserFriendlyError(err))
+			} else {
+				b.logger.Error("Failed to send request: %v", err)
+			}
+			b.stats.recordError()
 			// Send error response back to stdout
 			b.sendErrorResponse(line, err)
+			continue
+		}
+		if !isCall {
+			// Notifications and responses complete once sent
+			span.End()
 		}
+		b.stats.recordSent(len(line))
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -190,6 +531,16 @@ func (b *Bridge) readStdin(ctx context.Context, conn mcp.Connection) error {
 
 // handleResponses reads responses from the connection and writes them to stdout.
 func (b *Bridge) handleResponses(ctx context.Context, conn mcp.Connection) error {
+	var throttle *notificationThrottle
+	if b.config.MaxLineRate > 0 {
+		throttle = newNotificationThrottle(b.config.MaxLineRate, b.config.NotificationSpillBytes)
+		go func() {
+			if err := throttle.run(ctx, b.writeNotification); err != nil {
+				b.logger.Error("Failed to write notification to stdout: %v", err)
+			}
+		}()
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -208,6 +559,7 @@ func (b *Bridge) handleResponses(ctx context.Context, conn mcp.Connection) error
 			}
 			if err == io.EOF {
 				b.logger.Info("Connection closed by server")
+				b.failPending(CodeConnectionClosed, "server connection closed")
 				return nil
 			}
 			// Timeout is ok, just continue
@@ -215,42 +567,262 @@ func (b *Bridge) handleResponses(ctx context.Context, conn mcp.Connection) error
 				continue
 			}
 			b.logger.Error("Failed to read from connection: %v", err)
+			b.stats.recordError()
 			return err
 		}
 
-		// Encode the message to JSON using the SDK's jsonrpc package
+		var method string
+		resp, isResponse := msg.(*jsonrpc.Response)
+		if isResponse {
+			// The request stays pending until its response is written, so
+			// that draining after stdin EOF waits for the write too
+			req, _ := b.inflight.get(resp.ID)
+			method = req.Method
+		}
+
+		// Encode the message to JSON using the SDK's jsonrpc package.
+		// Re-encoding always yields compact, single-line JSON, whatever the
+		// server's formatting.
 		data, err := jsonrpc.EncodeMessage(msg)
 		if err != nil {
 			b.logger.Error("Failed to encode response: %v", err)
+			b.stats.recordError()
+			if isResponse {
+				// Answer the request, or the client would wait for it forever
+				b.writeErrorResponse(resp.ID.Raw(), method, CodeServerError, "failed to encode server response: "+err.Error())
+				if req, ok := b.inflight.remove(resp.ID); ok {
+					tracing.EndSpan(req.Span, err)
+				}
+			}
 			continue
 		}
 
-		b.logger.Debug("Received response from server: %s", string(data))
+		if isResponse && method == "initialize" && resp.Error == nil {
+			b.checkProtocolVersion(protocolVersionOf(resp.Result))
+		}
+
+		if isResponse && method == "tools/list" && resp.Error == nil && b.config.ValidateParams {
+			for _, name := range b.toolSchemas.update(resp.Result) {
+				b.logger.Debug("Not validating calls to tool %s: unsupported input schema", name)
+			}
+		}
+
+		if isResponse && resp.Error != nil {
+			var wire struct {
+				Error struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			json.Unmarshal(data, &wire)
+			b.errorLog.record(errorOriginServer, resp.ID.Raw(), method, wire.Error.Code, wire.Error.Message)
+		}
+
+		if limit := b.config.MaxClientResponseBytes; isResponse && limit > 0 && len(data) > limit {
+			message := fmt.Sprintf("response of %d bytes exceeds the client limit of %d bytes", len(data), limit)
+			b.logger.Warn("Replacing response to %s with an error: %s (--max-client-response-bytes)", method, message)
+			b.stats.recordError()
+			b.errorLog.record(errorOriginBridge, resp.ID.Raw(), method, CodeServerError, message)
+			data, _ = json.Marshal(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      resp.ID.Raw(),
+				"error": map[string]interface{}{
+					"code":    CodeServerError,
+					"message": message,
+				},
+			})
+			resp.Error = errors.New(message)
+		}
+
+		b.logger.Debug("Received response from server: %s", logJSON{data, b.config.LogMaxString})
+
+		req, isRequest := msg.(*jsonrpc.Request)
+		if isRequest && !req.IsCall() {
+			// Notifications are throttled; responses and server requests are not
+			if throttle != nil {
+				wasSpilling := throttle.spilling()
+				if !throttle.enqueue(data) {
+					b.logger.Error("Dropping notification %s: stdout rate limit exceeded", req.Method)
+					b.stats.recordError()
+				} else if !wasSpilling && throttle.spilling() {
+					b.logger.Warn("Stdout is falling behind; spilling notifications to disk")
+				}
+				continue
+			}
+			if err := b.writeNotification(data); err != nil {
+				return err
+			}
+			continue
+		}
 
 		// Write to stdout
-		if _, err := fmt.Fprintln(b.stdout, string(data)); err != nil {
-			return fmt.Errorf("failed to write to stdout: %w", err)
+		if err := b.writeReceived(data); err != nil {
+			return err
+		}
+		if isResponse {
+			if req, ok := b.inflight.remove(resp.ID); ok {
+				tracing.EndSpan(req.Span, resp.Error)
+			}
 		}
 	}
 }
 
+// checkProtocolVersion records the protocol version the server returned from
+// initialize, warning if the bridge does not support it, or if it differs
+// from the client's when configured to.
+func (b *Bridge) checkProtocolVersion(negotiated string) {
+	b.stats.recordProtocolVersion(negotiated)
+	b.negotiatedVersion.Store(negotiated)
+	requested, _ := b.requestedVersion.Load().(string)
+	b.logger.Debug("Negotiated MCP protocol version: %s (requested %s)", negotiated, requested)
+	if unsupportedProtocolVersion(negotiated) {
+		b.logger.Warn("Server negotiated MCP protocol version %s, which this bridge does not support (client requested %s; supported: %s); "+
+			"the client may fail to talk to this server", negotiated, requested, supportedProtocolVersionList())
+		return
+	}
+	if b.config.WarnProtocolMismatch && requested != "" && negotiated != requested {
+		b.logger.Warn("Server negotiated MCP protocol version %s, but the client requested %s", negotiated, requested)
+	}
+}
+
+// writeNotification writes a server notification to the notification output,
+// or to stdout if none is set.
+func (b *Bridge) writeNotification(data []byte) error {
+	if b.notifications == nil {
+		return b.writeReceived(data)
+	}
+	b.notificationsMu.Lock()
+	err := writeFramed(b.notifications, b.config.Framing, data)
+	if err == nil && b.recorder != nil {
+		b.recorder.record(RecordResponse, data)
+	}
+	b.notificationsMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+	if b.mirror != nil {
+		b.mirror.write(data)
+	}
+	b.stats.recordReceived(len(data))
+	return nil
+}
+
+// writeReceived writes a server message to stdout.
+func (b *Bridge) writeReceived(data []byte) error {
+	if err := b.writeMessage(data); err != nil {
+		return fmt.Errorf("failed to write to stdout: %w", err)
+	}
+	if b.mirror != nil {
+		b.mirror.write(data)
+	}
+	b.stats.recordReceived(len(data))
+	return nil
+}
+
+// writeMessage writes data as a single message to stdout.
+// A failed write may have left a partial message on stdout, so it is fatal:
+// later writes fail without writing and Run shuts the bridge down.
+func (b *Bridge) writeMessage(data []byte) error {
+	b.stdoutMu.Lock()
+	defer b.stdoutMu.Unlock()
+	if b.shot == nil {
+		return b.writeStdoutLocked(data)
+	}
+	answer, ok := b.shot.claim(data)
+	if !ok {
+		b.logger.Debug("Dropping a second response to the single-shot request")
+		return nil
+	}
+	if err := b.writeStdoutLocked(data); err != nil {
+		return err
+	}
+	if answer {
+		b.shot.finish()
+	}
+	return nil
+}
+
+// writeStdoutLocked is writeMessage for callers holding stdoutMu.
+func (b *Bridge) writeStdoutLocked(data []byte) error {
+	if b.stdoutErr != nil {
+		return b.stdoutErr
+	}
+	if err := writeFramed(b.stdout, b.config.Framing, data); err != nil {
+		b.stdoutErr = err
+		close(b.stdoutBroken)
+		return err
+	}
+	if b.recorder != nil {
+		b.recorder.record(RecordResponse, data)
+	}
+	return nil
+}
+
+// withHeaders returns a copy of client that adds headers to every request.
+func withHeaders(client *http.Client, headers map[string]string) *http.Client {
+	c := *client
+	c.Transport = transport.NewHeaderTransport(client.Transport, headers)
+	return &c
+}
+
+// failPending answers every in-flight request with a JSON-RPC error.
+func (b *Bridge) failPending(code int, message string) {
+	for _, req := range b.inflight.drain() {
+		b.logger.Debug("Failing pending request %v (%s): %s", req.ID.Raw(), req.Method, message)
+		tracing.EndSpan(req.Span, errors.New(message))
+		b.writeErrorResponse(req.ID.Raw(), req.Method, code, message)
+	}
+}
+
 // sendErrorResponse sends a JSON-RPC error response to stdout.
 func (b *Bridge) sendErrorResponse(request []byte, err error) {
 	// Try to extract the request ID
 	var req struct {
-		ID interface{} `json:"id"`
+		ID     interface{} `json:"id"`
+		Method string      `json:"method"`
 	}
 	json.Unmarshal(request, &req)
 
+	b.writeErrorResponse(req.ID, req.Method, CodeServerError, err.Error())
+}
+
+// writeErrorResponse writes a JSON-RPC error response with the given ID to stdout.
+// method is the method of the failed request, used for error logging.
+func (b *Bridge) writeErrorResponse(id interface{}, method string, code int, message string) {
+	b.errorLog.record(errorOriginBridge, id, method, code, message)
+	b.writeMessage(errorResponse(id, code, message))
+}
+
+// errorResponse returns a JSON-RPC error response to the request with id.
+func errorResponse(id interface{}, code int, message string) []byte {
 	response := map[string]interface{}{
 		"jsonrpc": "2.0",
-		"id":      req.ID,
+		"id":      id,
 		"error": map[string]interface{}{
-			"code":    -32000,
-			"message": err.Error(),
+			"code":    code,
+			"message": message,
 		},
 	}
 
 	data, _ := json.Marshal(response)
-	fmt.Fprintln(b.stdout, string(data))
+	return data
+}
+
+// isBatch reports whether the JSON value in line is an array.
+func isBatch(line []byte) bool {
+	trimmed := bytes.TrimLeft(line, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// TransportForSpec returns the transport defined by an MCP spec revision,
+// config.CompatSSE or config.CompatStreamable.
+func TransportForSpec(version string) (TransportType, error) {
+	switch version {
+	case config.CompatSSE:
+		return TransportSSE, nil
+	case config.CompatStreamable:
+		return TransportStreamable, nil
+	default:
+		return "", fmt.Errorf("no transport for MCP spec revision %q", version)
+	}
 }
@@ -0,0 +1,99 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// wellKnownPath is where MCP deployments may publish their transport details.
+const wellKnownPath = "/.well-known/mcp"
+
+// maxWellKnownSize limits the size of a discovery document.
+const maxWellKnownSize = 64 * 1024
+
+// WellKnownDocument is the transport description served at /.well-known/mcp.
+type WellKnownDocument struct {
+	Transport       string `json:"transport"`
+	SSEEndpoint     string `json:"sse_endpoint,omitempty"`
+	MessageEndpoint string `json:"message_endpoint,omitempty"`
+}
+
+// Discovery is the result of reading a server's well-known document.
+type Discovery struct {
+	Transport TransportType
+	Endpoint  string // Absolute URL to connect to with Transport
+}
+
+// Discover fetches the /.well-known/mcp document from the origin of serverURL
+// and returns the transport and endpoint it describes. It returns nil without
+// an error if the server does not publish a document (HTTP 404), and an error
+// if the endpoint is on another origin than serverURL.
+func Discover(ctx context.Context, client *http.Client, serverURL string) (*Discovery, error) {
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	docURL := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: wellKnownPath}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, docURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: unexpected status %s", docURL, resp.Status)
+	}
+
+	var doc WellKnownDocument
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxWellKnownSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: invalid document: %w", docURL, err)
+	}
+	return doc.resolve(docURL)
+}
+
+// resolve validates the document and resolves its endpoint against docURL.
+func (doc *WellKnownDocument) resolve(docURL *url.URL) (*Discovery, error) {
+	var d Discovery
+	var endpoint string
+	switch strings.ToLower(doc.Transport) {
+	case "sse":
+		// The message endpoint is announced on the SSE stream itself
+		d.Transport = TransportSSE
+		endpoint = doc.SSEEndpoint
+	case "streamable", "streamable-http", "streamablehttp":
+		d.Transport = TransportStreamable
+		endpoint = doc.MessageEndpoint
+	default:
+		return nil, fmt.Errorf("%s: unsupported transport %q", docURL, doc.Transport)
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("%s: no endpoint for %s transport", docURL, d.Transport)
+	}
+
+	ref, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid endpoint: %w", docURL, err)
+	}
+	resolved := docURL.ResolveReference(ref)
+	// Server headers and credentials are sent to the endpoint, so it must
+	// not move them to another host or downgrade them to plain http://
+	if resolved.Scheme != docURL.Scheme || !strings.EqualFold(resolved.Host, docURL.Host) {
+		return nil, fmt.Errorf("%s: endpoint %s is not on the server's origin %s://%s", docURL, resolved.Redacted(), docURL.Scheme, docURL.Host)
+	}
+	d.Endpoint = resolved.String()
+	return &d, nil
+}
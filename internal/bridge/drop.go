@@ -0,0 +1,122 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/iiharu/mcp-over-socks/internal/transport"
+)
+
+// connFailureKey is the context key for the connFailure of the request
+// being sent.
+type connFailureKey struct{}
+
+// connFailure records why the connection for a request failed. The SDK
+// transports flatten errors with %v, so the classification is made by
+// dropCheckTransport, where the error still has its type, and read back by
+// the caller of Write.
+type connFailure struct {
+	mu  sync.Mutex
+	err error
+}
+
+// withConnFailure returns a copy of ctx that records connection failures of
+// requests sent with it.
+func withConnFailure(ctx context.Context) (context.Context, *connFailure) {
+	f := &connFailure{}
+	return context.WithValue(ctx, connFailureKey{}, f), f
+}
+
+// get returns the classified failure, or nil if the connection did not fail
+// in a way dropCheckTransport recognizes.
+func (f *connFailure) get() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *connFailure) set(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
+
+// dropCheckTransport classifies failed round trips of requests sent with
+// withConnFailure by the phase they failed in.
+type dropCheckTransport struct {
+	base http.RoundTripper
+}
+
+// withDropCheck returns a copy of client that classifies connection
+// failures (see classifyConnError).
+func withDropCheck(client *http.Client) *http.Client {
+	c := *client
+	c.Transport = &dropCheckTransport{base: client.Transport}
+	return &c
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *dropCheckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	failure, _ := req.Context().Value(connFailureKey{}).(*connFailure)
+	if failure == nil {
+		return base.RoundTrip(req)
+	}
+
+	var responding atomic.Bool
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { responding.Store(true) },
+	}
+	resp, err := base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		if classified := classifyConnError(err, responding.Load()); classified != nil {
+			failure.set(classified)
+		}
+	}
+	return resp, err
+}
+
+// classifyConnError returns err wrapped with the side at fault, or nil if
+// it is not a connection failure:
+//
+//   - A *transport.SOCKSError happened while connecting to or negotiating
+//     with the proxy.
+//   - Any other failed dial is a direct connection to the server.
+//   - A connection closed after the server began responding was dropped by
+//     the server.
+//   - A connection closed before the first response byte is not blamed on
+//     either side: the proxy, the server closing after reading the request
+//     and a stale keep-alive connection reset on reuse all look the same.
+func classifyConnError(err error, responding bool) error {
+	var socksErr *transport.SOCKSError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &socksErr):
+		return WrapError(ErrProxyConnection, err.Error())
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return WrapError(ErrServerConnection, err.Error())
+	case !isConnectionDrop(err):
+		return nil
+	case responding:
+		return WrapError(ErrServerConnection, "server closed the connection mid-response: "+err.Error())
+	default:
+		return WrapError(ErrConnectionClosed, "connection dropped before the response: "+err.Error())
+	}
+}
+
+// isConnectionDrop reports whether err means an established connection was
+// closed.
+func isConnectionDrop(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,393 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+
+	"github.com/iiharu/mcp-over-socks/internal/config"
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// CodeInvalidEnvelope is used when the server sent a message with a missing or
+// wrong "jsonrpc" version and strict mode replaced it.
+const CodeInvalidEnvelope = -32603
+
+// SSEKeepaliveMethod is the notification sent to the client in place of an
+// SSE event with empty data, with --sse-empty-data forward.
+const SSEKeepaliveMethod = "notifications/sse_keepalive"
+
+// envelopeTransport checks server messages before the SDK decodes them.
+// SSE keepalive events (see --sse-ping-event) are consumed, since the SDK
+// would otherwise try to decode them as JSON-RPC messages.
+//
+// If normalize or strict is set, the "jsonrpc" version of each message is
+// also checked. The SDK rejects a bad version outright (which, for Streamable
+// HTTP, breaks the whole connection), so malformed envelopes are normalized
+// to "2.0" with a warning. In strict mode, malformed responses are instead
+// replaced with a JSON-RPC error for the same id; messages without an id
+// cannot be answered and are still normalized.
+//
+// SSE events with empty data are handled according to the emptyData policy
+// (see emptyEvent), and those whose data is over maxEventData bytes (if
+// positive) according to the oversized policy (see limitEvent).
+type envelopeTransport struct {
+	base         http.RoundTripper
+	logger       *logging.Logger
+	normalize    bool
+	strict       bool
+	pingEvent    string
+	emptyData    string
+	maxEventData int
+	oversized    string
+}
+
+// withEnvelopeCheck returns a copy of client that checks inbound JSON-RPC
+// envelopes (if normalize or strict is set), drops SSE events named pingEvent
+// (if not empty), handles SSE events with empty data with the emptyData
+// policy, and limits SSE event data to maxEventData bytes (if positive) with
+// the oversized policy.
+func withEnvelopeCheck(client *http.Client, logger *logging.Logger, normalize, strict bool, pingEvent, emptyData string, maxEventData int, oversized string) *http.Client {
+	c := *client
+	c.Transport = &envelopeTransport{
+		base:         client.Transport,
+		logger:       logger,
+		normalize:    normalize,
+		strict:       strict,
+		pingEvent:    pingEvent,
+		emptyData:    emptyData,
+		maxEventData: maxEventData,
+		oversized:    oversized,
+	}
+	return &c
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *envelopeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = t.fix(body)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Del("Content-Length")
+	case "text/event-stream":
+		rewriter := newSSEDataRewriter(resp.Body, t.fix, t.isPing)
+		rewriter.empty = t.emptyEvent
+		if t.maxEventData > 0 {
+			rewriter.limit, rewriter.oversized = t.maxEventData, t.limitEvent
+		}
+		resp.Body = rewriter
+	}
+	return resp, nil
+}
+
+// isPing reports whether an SSE event is a server keepalive.
+func (t *envelopeTransport) isPing(event string) bool {
+	if t.pingEvent == "" || event != t.pingEvent {
+		return false
+	}
+	t.logger.Debug("Received SSE %q keepalive", event)
+	return true
+}
+
+// emptyEvent returns the data of the event to forward in place of an SSE
+// event with empty data: a keepalive notification with the forward policy,
+// and nil (dropping the event) otherwise.
+func (t *envelopeTransport) emptyEvent() []byte {
+	if t.emptyData != config.SSEEmptyDataForward {
+		t.logger.Debug("Dropped SSE event with empty data")
+		return nil
+	}
+	t.logger.Debug("Forwarding SSE event with empty data as %s", SSEKeepaliveMethod)
+	return []byte(`{"jsonrpc":"2.0","method":"` + SSEKeepaliveMethod + `"}`)
+}
+
+// fix returns data with a valid "jsonrpc" version, or data unchanged if it
+// is not a single JSON object, is already valid, or envelopes are not
+// checked.
+func (t *envelopeTransport) fix(data []byte) []byte {
+	if !t.normalize && !t.strict {
+		return data
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return data
+	}
+	if version, ok := msg["jsonrpc"]; ok && string(version) == `"2.0"` {
+		return data
+	}
+
+	version := "missing"
+	if raw, ok := msg["jsonrpc"]; ok {
+		version = string(raw)
+	}
+	id, hasID := msg["id"]
+	_, isRequest := msg["method"]
+
+	if t.strict && hasID && !isRequest {
+		t.logger.Warn("Server sent a response with invalid jsonrpc version (%s); replacing it with an error (--strict)", version)
+		fixed, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      id,
+			"error": map[string]interface{}{
+				"code":    CodeInvalidEnvelope,
+				"message": "server sent a malformed JSON-RPC envelope (jsonrpc: " + version + ")",
+			},
+		})
+		return fixed
+	}
+
+	t.logger.Warn("Server sent a message with invalid jsonrpc version (%s); normalizing to \"2.0\"", version)
+	msg["jsonrpc"] = json.RawMessage(`"2.0"`)
+	fixed, err := json.Marshal(msg)
+	if err != nil {
+		return data
+	}
+	return fixed
+}
+
+// limitEvent handles SSE event data over the size limit, returning the data
+// of the events to forward in its place:
+//
+//   - reject drops the event. A response is replaced with an error for its
+//     id, so that the client is not left waiting.
+//   - truncate abbreviates string values, longest first, until the message
+//     fits, and rejects it if it cannot be made to fit.
+//   - split forwards each message of a batch as its own event. This is best
+//     effort: single messages and batches with an oversized member cannot be
+//     split and are rejected.
+func (t *envelopeTransport) limitEvent(data []byte) [][]byte {
+	switch t.oversized {
+	case config.OversizedEventTruncate:
+		if clipped, ok := clipStrings(data, t.maxEventData); ok {
+			t.logger.Warn("Truncated %d-byte SSE event to %d bytes (--max-event-data-bytes %d)", len(data), len(clipped), t.maxEventData)
+			return [][]byte{clipped}
+		}
+	case config.OversizedEventSplit:
+		var batch []json.RawMessage
+		if json.Unmarshal(data, &batch) == nil && len(batch) > 0 {
+			parts := make([][]byte, len(batch))
+			for i, msg := range batch {
+				parts[i] = msg
+			}
+			if !slices.ContainsFunc(parts, func(p []byte) bool { return len(p) > t.maxEventData }) {
+				t.logger.Warn("Split %d-byte SSE event into %d events (--max-event-data-bytes %d)", len(data), len(parts), t.maxEventData)
+				return parts
+			}
+		}
+	}
+
+	var msg struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	json.Unmarshal(data, &msg)
+	if len(msg.ID) == 0 || string(msg.ID) == "null" || msg.Method != "" {
+		t.logger.Warn("Dropped %d-byte SSE event over --max-event-data-bytes %d", len(data), t.maxEventData)
+		return nil
+	}
+	t.logger.Warn("Replaced %d-byte SSE response for id %s with an error (--max-event-data-bytes %d)", len(data), msg.ID, t.maxEventData)
+	reply, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      msg.ID,
+		"error": map[string]interface{}{
+			"code":    CodeServerError,
+			"message": fmt.Sprintf("server message of %d bytes exceeds the bridge's %d-byte event limit", len(data), t.maxEventData),
+		},
+	})
+	return [][]byte{reply}
+}
+
+// clipStrings abbreviates the longest string values in the JSON value data
+// (as in debug logs) until it encodes to at most max bytes. It reports false
+// if the message does not fit even with all but short strings abbreviated.
+func clipStrings(data []byte, max int) ([]byte, bool) {
+	// Strings this short (e.g. "jsonrpc" and method names) are never clipped
+	const minLimit = 64
+	for limit := max; limit >= minLimit; limit /= 2 {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, false
+		}
+		var out bytes.Buffer
+		enc := json.NewEncoder(&out)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(truncateStrings(v, limit)); err != nil {
+			return nil, false
+		}
+		if clipped := bytes.TrimSuffix(out.Bytes(), []byte("\n")); len(clipped) <= max {
+			return clipped, true
+		}
+	}
+	return nil, false
+}
+
+// utf8BOM is the UTF-8 encoding of the byte order mark, U+FEFF.
+var utf8BOM = []byte("\uFEFF")
+
+// sseDataRewriter rewrites the data of each SSE event in a stream, and drops
+// events for which skip returns true. Events are buffered until complete;
+// each event's data is emitted as a single line.
+type sseDataRewriter struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	rewrite func([]byte) []byte
+	skip    func(event string) bool
+
+	// limit, if positive, is the largest event data passed on as is; data
+	// over it is replaced with that of the events oversized returns.
+	limit     int
+	oversized func(data []byte) [][]byte
+
+	// empty, if not nil, returns the data to emit in place of an event with
+	// empty data, or nil to drop the event.
+	empty func() []byte
+
+	name    string   // Event name of the pending event
+	hasName bool     // Whether the pending event has an "event" field
+	fields  [][]byte // Other non-data lines of the pending event
+	data    [][]byte // Data of the pending event
+	out     bytes.Buffer
+	err     error
+}
+
+// newSSEDataRewriter wraps an SSE body, applying rewrite to each event's data.
+func newSSEDataRewriter(body io.ReadCloser, rewrite func([]byte) []byte, skip func(event string) bool) *sseDataRewriter {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, MaxMessageSize)
+	return &sseDataRewriter{
+		body:    body,
+		scanner: scanner,
+		rewrite: rewrite,
+		skip:    skip,
+	}
+}
+
+// Read implements io.Reader.
+func (r *sseDataRewriter) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 && r.err == nil {
+		if !r.scanner.Scan() {
+			r.err = r.scanner.Err()
+			if r.err == nil {
+				r.err = io.EOF
+			}
+			r.flushEvent()
+			break
+		}
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			r.flushEvent()
+			continue
+		}
+		r.parseField(line)
+	}
+	if r.out.Len() > 0 {
+		return r.out.Read(p)
+	}
+	return 0, r.err
+}
+
+// parseField adds a non-empty line to the pending event, as in the SSE
+// spec: the field name runs up to the first colon, and a single space after
+// it is not part of the value. A line without a colon is a field with an
+// empty value (so a bare "data" line adds an empty data line), and fields
+// may come in any order, with the last "event" field naming the event.
+// Comments and other fields are passed on as "name: value" lines, since the
+// SDK rejects lines without a colon.
+func (r *sseDataRewriter) parseField(line []byte) {
+	if line[0] == ':' {
+		r.fields = append(r.fields, bytes.Clone(line))
+		return
+	}
+	name, value, _ := bytes.Cut(line, []byte(":"))
+	value = bytes.TrimPrefix(value, []byte(" "))
+	switch string(name) {
+	case "data":
+		r.data = append(r.data, bytes.Clone(value))
+	case "event":
+		r.name, r.hasName = string(value), true
+	default:
+		field := make([]byte, 0, len(name)+2+len(value))
+		field = append(append(append(field, name...), ": "...), value...)
+		r.fields = append(r.fields, field)
+	}
+}
+
+// flushEvent emits the pending event, unless it is skipped.
+func (r *sseDataRewriter) flushEvent() {
+	name, hasName, fields, data := r.name, r.hasName, r.fields, r.data
+	r.name, r.hasName, r.fields, r.data = "", false, nil, nil
+	if !hasName && fields == nil && data == nil {
+		return
+	}
+	if name != "" && r.skip != nil && r.skip(name) {
+		return
+	}
+	if hasName {
+		fields = append([][]byte{[]byte("event: " + name)}, fields...)
+	}
+
+	if data == nil {
+		r.writeEvent(fields, nil)
+		return
+	}
+	// Servers sometimes prefix the data with a byte order mark or stray
+	// whitespace, neither of which is part of the JSON message
+	joined := bytes.TrimSpace(bytes.TrimPrefix(bytes.Join(data, []byte("\n")), utf8BOM))
+	if len(joined) == 0 && r.empty != nil {
+		if replacement := r.empty(); replacement != nil {
+			r.writeEvent(fields, replacement)
+		}
+		return
+	}
+	if r.limit > 0 && len(joined) > r.limit {
+		for _, part := range r.oversized(joined) {
+			r.writeEvent(fields, r.rewrite(part))
+		}
+		return
+	}
+	r.writeEvent(fields, r.rewrite(joined))
+}
+
+// writeEvent writes an event with the given non-data lines and data (if not
+// nil) to the output.
+func (r *sseDataRewriter) writeEvent(fields [][]byte, data []byte) {
+	for _, line := range fields {
+		r.out.Write(line)
+		r.out.WriteByte('\n')
+	}
+	if data != nil {
+		r.out.WriteString("data: ")
+		// Keep the SSE framing intact if the data still spans lines
+		r.out.Write(bytes.ReplaceAll(data, []byte("\n"), []byte("\ndata: ")))
+		r.out.WriteByte('\n')
+	}
+	r.out.WriteByte('\n')
+}
+
+// Close implements io.Closer.
+func (r *sseDataRewriter) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,54 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Origins of JSON-RPC error responses recorded in the error log.
+const (
+	errorOriginServer = "server"
+	errorOriginBridge = "bridge"
+)
+
+// errorLogEntry is a single line of the error log.
+type errorLogEntry struct {
+	Time    string      `json:"time"`
+	Origin  string      `json:"origin"`
+	ID      interface{} `json:"id"`
+	Method  string      `json:"method,omitempty"`
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+}
+
+// errorLog appends JSON-RPC error responses to a writer, one JSON object per line.
+type errorLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// record appends an error response to the log.
+// A nil errorLog discards all records.
+func (l *errorLog) record(origin string, id interface{}, method string, code int, message string) {
+	if l == nil {
+		return
+	}
+	data, err := json.Marshal(errorLogEntry{
+		Time:    time.Now().Format(time.RFC3339Nano),
+		Origin:  origin,
+		ID:      id,
+		Method:  method,
+		Code:    code,
+		Message: message,
+	})
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.w, string(data))
+}
@@ -1,7 +1,12 @@
 // Package bridge provides the MCP bridge between stdio and SSE transport.
 package bridge
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
 
 // Error types for the bridge.
 var (
@@ -21,6 +26,22 @@ var (
 	ErrConnectionClosed = errors.New("connection closed")
 )
 
+// JSON-RPC error codes used in responses synthesized by the bridge.
+const (
+	// CodeServerError is the generic server error code.
+	CodeServerError = -32000
+
+	// CodeConnectionClosed is used when the server connection closed before
+	// a pending request was answered.
+	CodeConnectionClosed = -32003
+
+	// CodeInvalidRequest is used when a request is rejected by the bridge.
+	CodeInvalidRequest = -32600
+
+	// CodeInvalidParams is used when request params fail validation.
+	CodeInvalidParams = -32602
+)
+
 // WrapError wraps an error with a more user-friendly message.
 func WrapError(err error, message string) error {
 	if err == nil {
@@ -49,6 +70,20 @@ func (e *BridgeError) Unwrap() error {
 	return e.Err
 }
 
+// isTimeout reports whether err is a deadline or network timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// The SDK transports flatten some errors with %v, losing their type
+	msg := err.Error()
+	return strings.Contains(msg, "Client.Timeout exceeded") || strings.Contains(msg, context.DeadlineExceeded.Error())
+}
+
 // IsProxyError checks if the error is related to proxy connection.
 func IsProxyError(err error) bool {
 	return errors.Is(err, ErrProxyConnection)
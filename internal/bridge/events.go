@@ -0,0 +1,148 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/config"
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// Connection lifecycle event types.
+const (
+	EventConnected    = "connected"
+	EventDisconnected = "disconnected"
+	EventError        = "error"
+)
+
+// Event is a connection lifecycle event.
+type Event struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Server string    `json:"server"` // Server URL without credentials or query
+	Error  string    `json:"error,omitempty"`
+}
+
+// SetEventHandler calls h for every connection lifecycle event. h must not
+// block. It must be called before Run.
+func (b *Bridge) SetEventHandler(h func(Event)) {
+	b.onEvent = h
+}
+
+// emit reports a lifecycle event to the event handler, if any.
+func (b *Bridge) emit(eventType string, err error) {
+	if b.onEvent == nil {
+		return
+	}
+	server := config.RedactURL(b.config.ServerURL)
+	e := Event{Type: eventType, Time: time.Now().UTC(), Server: server}
+	if err != nil {
+		// Errors often quote the full server URL
+		e.Error = strings.ReplaceAll(err.Error(), b.config.ServerURL, server)
+	}
+	b.onEvent(e)
+}
+
+// Webhook delivery limits.
+const (
+	webhookQueueSize = 32
+	webhookAttempts  = 3
+	webhookTimeout   = 5 * time.Second
+	webhookBackoff   = 200 * time.Millisecond
+)
+
+// WebhookNotifier posts events as JSON to a URL in the background, so that a
+// slow or failing webhook never stalls the bridge. Events that arrive while
+// the queue is full are dropped.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+	logger *logging.Logger
+
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewWebhookNotifier starts a notifier posting to webhookURL with client.
+func NewWebhookNotifier(webhookURL string, client *http.Client, logger *logging.Logger) *WebhookNotifier {
+	n := &WebhookNotifier{
+		url:    webhookURL,
+		client: client,
+		logger: logger,
+		events: make(chan Event, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues e for delivery without blocking.
+func (n *WebhookNotifier) Notify(e Event) {
+	select {
+	case n.events <- e:
+	default:
+		n.logger.Warn("Dropping %s event: webhook queue is full", e.Type)
+	}
+}
+
+// Close stops accepting events and waits until queued events are delivered
+// or ctx is done.
+func (n *WebhookNotifier) Close(ctx context.Context) error {
+	n.once.Do(func() { close(n.events) })
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *WebhookNotifier) run() {
+	defer close(n.done)
+	for e := range n.events {
+		if err := n.deliver(e); err != nil {
+			n.logger.Warn("Failed to deliver %s event to webhook: %v", e.Type, err)
+		}
+	}
+}
+
+// deliver posts e, retrying failures a bounded number of times.
+func (n *WebhookNotifier) deliver(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = n.post(body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(webhookBackoff * time.Duration(attempt))
+	}
+}
+
+func (n *WebhookNotifier) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
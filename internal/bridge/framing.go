@@ -0,0 +1,95 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/iiharu/mcp-over-socks/internal/config"
+)
+
+// splitFunc returns the bufio.SplitFunc for reading messages with framing.
+func splitFunc(framing string) bufio.SplitFunc {
+	if framing == config.FramingHeader {
+		return splitHeaderFramed
+	}
+	return bufio.ScanLines
+}
+
+// writeFramed writes data as a single message to w with the given framing.
+// The whole frame is passed to one Write, so a message is never split across
+// writes by the bridge; short writes are retried until it is complete.
+func writeFramed(w io.Writer, framing string, data []byte) error {
+	var frame []byte
+	if framing == config.FramingHeader {
+		frame = fmt.Appendf(nil, "Content-Length: %d\r\n\r\n", len(data))
+		frame = append(frame, data...)
+	} else {
+		frame = make([]byte, 0, len(data)+1)
+		frame = append(append(frame, data...), '\n')
+	}
+	return writeFull(w, frame)
+}
+
+// writeFull writes all of p to w, retrying short writes that made progress.
+func writeFull(w io.Writer, p []byte) error {
+	for len(p) > 0 {
+		n, err := w.Write(p)
+		p = p[n:]
+		switch {
+		case n > 0 && (err == nil || errors.Is(err, io.ErrShortWrite)):
+			continue
+		case err != nil:
+			return err
+		default:
+			return io.ErrShortWrite
+		}
+	}
+	return nil
+}
+
+// splitHeaderFramed is a bufio.SplitFunc for LSP-style messages: a header
+// block with a Content-Length field, a blank line, then exactly that many
+// bytes of body. Other header fields (e.g. Content-Type) are ignored.
+func splitHeaderFramed(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	headerEnd := bytes.Index(data, []byte("\r\n\r\n"))
+	if headerEnd < 0 {
+		if atEOF && len(bytes.TrimSpace(data)) > 0 {
+			return 0, nil, errors.New("incomplete message header at EOF")
+		}
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+
+	length := -1
+	for _, line := range strings.Split(string(data[:headerEnd]), "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return 0, nil, fmt.Errorf("malformed message header %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || length < 0 {
+				return 0, nil, fmt.Errorf("invalid Content-Length %q", strings.TrimSpace(value))
+			}
+		}
+	}
+	if length < 0 {
+		return 0, nil, errors.New("message header without Content-Length")
+	}
+
+	bodyStart := headerEnd + len("\r\n\r\n")
+	if len(data)-bodyStart < length {
+		if atEOF {
+			return 0, nil, errors.New("incomplete message body at EOF")
+		}
+		return 0, nil, nil
+	}
+	return bodyStart + length, data[bodyStart : bodyStart+length], nil
+}
@@ -0,0 +1,115 @@
+package bridge
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonrpc"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// inflightRequest describes a client request awaiting a server response.
+type inflightRequest struct {
+	ID     jsonrpc.ID
+	Method string
+	SentAt time.Time
+	Span   trace.Span // Ended once the request completes
+}
+
+// inflightTracker tracks client requests that have been forwarded to the
+// server but not yet answered.
+type inflightTracker struct {
+	mu      sync.Mutex
+	pending map[jsonrpc.ID]inflightRequest
+	empty   chan struct{} // Closed while no requests are pending
+}
+
+// newInflightTracker creates an empty inflightTracker.
+func newInflightTracker() *inflightTracker {
+	empty := make(chan struct{})
+	close(empty)
+	return &inflightTracker{
+		pending: make(map[jsonrpc.ID]inflightRequest),
+		empty:   empty,
+	}
+}
+
+// add starts tracking a request, traced by span.
+func (t *inflightTracker) add(req *jsonrpc.Request, span trace.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) == 0 {
+		t.empty = make(chan struct{})
+	}
+	t.pending[req.ID] = inflightRequest{
+		ID:     req.ID,
+		Method: req.Method,
+		SentAt: time.Now(),
+		Span:   span,
+	}
+}
+
+// get returns the pending request with the given ID.
+func (t *inflightTracker) get(id jsonrpc.ID) (inflightRequest, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	req, ok := t.pending[id]
+	return req, ok
+}
+
+// remove stops tracking the request with the given ID and returns it.
+func (t *inflightTracker) remove(id jsonrpc.ID) (inflightRequest, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	req, ok := t.pending[id]
+	delete(t.pending, id)
+	t.signalIfEmpty()
+	return req, ok
+}
+
+// drain stops tracking all requests and returns them.
+func (t *inflightTracker) drain() []inflightRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reqs := make([]inflightRequest, 0, len(t.pending))
+	for id, req := range t.pending {
+		reqs = append(reqs, req)
+		delete(t.pending, id)
+	}
+	t.signalIfEmpty()
+	return reqs
+}
+
+// wait blocks until no requests are pending or ctx is done.
+func (t *inflightTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	empty := t.empty
+	t.mu.Unlock()
+	select {
+	case <-empty:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// len returns the number of pending requests.
+func (t *inflightTracker) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// signalIfEmpty wakes waiters once no requests are pending.
+// The caller must hold t.mu.
+func (t *inflightTracker) signalIfEmpty() {
+	if len(t.pending) > 0 {
+		return
+	}
+	select {
+	case <-t.empty:
+	default:
+		close(t.empty)
+	}
+}
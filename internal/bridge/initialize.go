@@ -0,0 +1,70 @@
+package bridge
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// supportedProtocolVersions are the MCP protocol versions the bridge's
+// transports implement, newest first.
+var supportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// protocolVersionOf returns the protocolVersion field of initialize params
+// or result, or "" if there is none.
+func protocolVersionOf(raw json.RawMessage) string {
+	var v struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(raw, &v)
+	return v.ProtocolVersion
+}
+
+// unsupportedProtocolVersion reports whether version is one the bridge does
+// not implement.
+func unsupportedProtocolVersion(version string) bool {
+	return version != "" && !slices.Contains(supportedProtocolVersions, version)
+}
+
+// supportedProtocolVersionList returns the supported versions for messages.
+func supportedProtocolVersionList() string {
+	return strings.Join(supportedProtocolVersions, ", ")
+}
+
+// stripCapabilities removes the named client capabilities from the params of
+// an initialize request. It returns the rewritten params and the names that
+// were actually removed.
+func stripCapabilities(params json.RawMessage, names []string) (json.RawMessage, []string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params, &fields); err != nil {
+		return nil, nil, err
+	}
+	var capabilities map[string]json.RawMessage
+	if raw, ok := fields["capabilities"]; ok {
+		if err := json.Unmarshal(raw, &capabilities); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var removed []string
+	for _, name := range names {
+		if _, ok := capabilities[name]; ok {
+			delete(capabilities, name)
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) == 0 {
+		return params, nil, nil
+	}
+
+	raw, err := json.Marshal(capabilities)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields["capabilities"] = raw
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rewritten, removed, nil
+}
@@ -0,0 +1,43 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// ServeListener accepts connections on ln one at a time and calls run for
+// each, which typically runs a Bridge using the connection as its stdin and
+// stdout. The next connection is accepted once run returns; errors from run
+// are logged and end only that session. It returns when ctx is done or ln
+// fails, and closes ln.
+func ServeListener(ctx context.Context, ln net.Listener, logger *logging.Logger, run func(ctx context.Context, conn net.Conn) error) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	defer ln.Close()
+
+	logger.Info("Waiting for a client on %s", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		logger.Info("Client connected from %s", conn.RemoteAddr())
+		err = run(ctx, conn)
+		conn.Close()
+		if err != nil {
+			logger.Error("Session with %s ended: %v", conn.RemoteAddr(), err)
+		} else {
+			logger.Info("Client %s disconnected", conn.RemoteAddr())
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonrpc"
+)
+
+// LocalHandler answers a client request in the bridge instead of forwarding
+// it to the server. It returns the JSON-RPC result, or an error that is sent
+// back as a JSON-RPC error response.
+type LocalHandler func(ctx context.Context, params json.RawMessage) (any, error)
+
+// builtinHandlers are the local handlers that can be enabled by name. Only
+// ping has one: the answer to every other MCP method depends on the server's
+// tools, resources or session state, which the bridge does not know.
+var builtinHandlers = map[string]LocalHandler{
+	// ping answers liveness checks without a round trip through the proxy
+	"ping": func(ctx context.Context, params json.RawMessage) (any, error) {
+		return struct{}{}, nil
+	},
+}
+
+// BuiltinLocalMethods returns the methods that have a built-in local handler.
+func BuiltinLocalMethods() []string {
+	methods := make([]string, 0, len(builtinHandlers))
+	for method := range builtinHandlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// HandleLocally makes the bridge answer requests for method with h instead of
+// forwarding them. Notifications are always forwarded. It must be called
+// before Run.
+func (b *Bridge) HandleLocally(method string, h LocalHandler) {
+	if b.localHandlers == nil {
+		b.localHandlers = make(map[string]LocalHandler)
+	}
+	b.localHandlers[method] = h
+}
+
+// UseBuiltinHandler answers requests for method with its built-in local
+// handler. It must be called before Run.
+func (b *Bridge) UseBuiltinHandler(method string) error {
+	h, ok := builtinHandlers[method]
+	if !ok {
+		return fmt.Errorf("no built-in handler for method %q (available: %v)", method, BuiltinLocalMethods())
+	}
+	b.HandleLocally(method, h)
+	return nil
+}
+
+// answerLocally runs h for req and writes its response to stdout. The
+// response is counted and mirrored like one from the server.
+func (b *Bridge) answerLocally(ctx context.Context, req *jsonrpc.Request, h LocalHandler) {
+	result, err := h(ctx, req.Params)
+	if err != nil {
+		b.stats.recordError()
+		b.writeErrorResponse(req.ID.Raw(), req.Method, CodeServerError, err.Error())
+		return
+	}
+	data, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      req.ID.Raw(),
+		"result":  result,
+	})
+	if err != nil {
+		b.logger.Error("Failed to encode local %s result: %v", req.Method, err)
+		b.stats.recordError()
+		b.writeErrorResponse(req.ID.Raw(), req.Method, CodeServerError, "failed to encode result")
+		return
+	}
+	if err := b.writeReceived(data); err != nil {
+		b.logger.Error("%v", err)
+	}
+}
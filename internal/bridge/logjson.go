@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// logJSON formats a JSON message for the log, replacing string values longer
+// than max bytes (e.g. base64 file contents) with a placeholder. The message
+// itself is not modified. Formatting is deferred until the log line is
+// actually written.
+type logJSON struct {
+	data []byte
+	max  int // Longest string value logged in full, 0 for no limit
+}
+
+// String implements fmt.Stringer.
+func (l logJSON) String() string {
+	if l.max <= 0 || len(l.data) <= l.max {
+		return string(l.data)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(l.data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return string(l.data)
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(truncateStrings(v, l.max)); err != nil {
+		return string(l.data)
+	}
+	return string(bytes.TrimSuffix(out.Bytes(), []byte("\n")))
+}
+
+// truncateStrings replaces string values longer than max bytes in v.
+func truncateStrings(v any, max int) any {
+	switch v := v.(type) {
+	case string:
+		if len(v) > max {
+			return fmt.Sprintf("...<%d bytes>", len(v))
+		}
+	case map[string]any:
+		for key, value := range v {
+			v[key] = truncateStrings(value, max)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = truncateStrings(value, max)
+		}
+	}
+	return v
+}
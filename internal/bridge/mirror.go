@@ -0,0 +1,108 @@
+package bridge
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// mirrorQueueSize is the number of messages buffered for a slow mirror.
+const mirrorQueueSize = 256
+
+// Mirror copies server messages to a secondary sink, such as a monitor
+// listening on a Unix socket, one message per line. Writes happen in the
+// background: messages are dropped while the queue is full, and the mirror
+// stops after its first write error. Neither affects stdout.
+type Mirror struct {
+	w      io.Writer
+	logger *logging.Logger
+
+	messages chan []byte   // Never closed, so writes racing Close are safe
+	stop     chan struct{} // Closed by Close
+	done     chan struct{} // Closed once run returns
+	once     sync.Once
+}
+
+// NewMirror starts a mirror writing to w.
+func NewMirror(w io.Writer, logger *logging.Logger) *Mirror {
+	m := &Mirror{
+		w:        w,
+		logger:   logger,
+		messages: make(chan []byte, mirrorQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// OpenMirrorSink opens the sink for --mirror: a connection if path is a Unix
+// socket, otherwise the file at path, appended to. A new file is readable
+// only by its owner, since it holds every server message.
+func OpenMirrorSink(path string) (io.WriteCloser, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		return net.Dial("unix", path)
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+}
+
+// SetMirror copies every server message forwarded to the client to m. It
+// must be called before Run.
+func (b *Bridge) SetMirror(m *Mirror) {
+	b.mirror = m
+}
+
+// write queues a copy of data without blocking. Messages written after
+// Close are ignored.
+func (m *Mirror) write(data []byte) {
+	select {
+	case <-m.stop:
+		return
+	case <-m.done:
+		return
+	default:
+	}
+	select {
+	case m.messages <- append([]byte(nil), data...):
+	default:
+		m.logger.Debug("Mirror is not keeping up; dropping a message")
+	}
+}
+
+// Close stops the mirror after the queued messages are written, or when ctx
+// is done, whichever comes first. A write stalled on the sink keeps the
+// mirror's goroutine running until the sink is closed.
+func (m *Mirror) Close(ctx context.Context) error {
+	m.once.Do(func() { close(m.stop) })
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *Mirror) run() {
+	defer close(m.done)
+	for {
+		var data []byte
+		select {
+		case data = <-m.messages:
+		case <-m.stop:
+			// Flush what was queued before Close
+			select {
+			case data = <-m.messages:
+			default:
+				return
+			}
+		}
+		if err := writeFramed(m.w, "", data); err != nil {
+			m.logger.Warn("Stopped mirroring server messages: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package bridge
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// negotiatedVersionTransport sets the MCP-Protocol-Version header to the
+// version negotiated by initialize on every request once it is known.
+type negotiatedVersionTransport struct {
+	base    http.RoundTripper
+	version *atomic.Value // string
+}
+
+// withNegotiatedVersion returns a copy of client that sends the protocol
+// version stored in version, if any.
+func withNegotiatedVersion(client *http.Client, version *atomic.Value) *http.Client {
+	c := *client
+	c.Transport = &negotiatedVersionTransport{base: client.Transport, version: version}
+	return &c
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *negotiatedVersionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if v, _ := t.version.Load().(string); v != "" {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Header.Set(protocolVersionHeader, v)
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
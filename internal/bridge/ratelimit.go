@@ -0,0 +1,153 @@
+package bridge
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// notificationQueueSize bounds the notifications buffered while throttled.
+// Notifications beyond it are dropped, since the overflow is sustained.
+const notificationQueueSize = 256
+
+// tokenBucket is a token bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full token bucket refilling at rate tokens per
+// second, holding at most one second's worth of tokens (and at least one).
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, rate)
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available or ctx is done.
+func (tb *tokenBucket) wait(ctx context.Context) error {
+	for {
+		tb.mu.Lock()
+		now := time.Now()
+		tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+		tb.last = now
+		if tb.tokens >= 1 {
+			tb.tokens--
+			tb.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+		tb.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// notificationThrottle rate-limits server notifications written to stdout.
+// Responses bypass it, so they are never delayed behind a notification burst.
+type notificationThrottle struct {
+	bucket *tokenBucket
+	queue  chan []byte
+	spill  *spillFile // Overflow from queue, nil to drop it
+}
+
+// newNotificationThrottle creates a throttle allowing rate notifications per
+// second. If spillBytes is positive, notifications overflowing the in-memory
+// buffer are kept in a temporary file of up to that size instead of dropped.
+func newNotificationThrottle(rate float64, spillBytes int64) *notificationThrottle {
+	t := &notificationThrottle{
+		bucket: newTokenBucket(rate),
+		queue:  make(chan []byte, notificationQueueSize),
+	}
+	if spillBytes > 0 {
+		t.spill = newSpillFile(spillBytes)
+	}
+	return t
+}
+
+// enqueue buffers a notification, returning false if the buffer is full. It
+// must not be called concurrently.
+func (t *notificationThrottle) enqueue(data []byte) bool {
+	// Once spilling, later notifications queue behind the spilled ones
+	if t.spill == nil || !t.spill.pending() {
+		select {
+		case t.queue <- data:
+			return true
+		default:
+		}
+	}
+	return t.spill != nil && t.spill.push(data) == nil
+}
+
+// spilling reports whether notifications are waiting on disk.
+func (t *notificationThrottle) spilling() bool {
+	return t.spill != nil && t.spill.pending()
+}
+
+// next returns the oldest buffered notification, waiting for one if there
+// are none, or reports false once ctx is done.
+func (t *notificationThrottle) next(ctx context.Context) ([]byte, bool, error) {
+	var spilled <-chan struct{}
+	if t.spill != nil {
+		spilled = t.spill.ready
+	}
+	for {
+		// The in-memory buffer only fills while nothing is spilled, so its
+		// notifications are older than any on disk
+		select {
+		case data := <-t.queue:
+			return data, true, nil
+		default:
+		}
+		if t.spill != nil {
+			if data, ok, err := t.spill.pop(); ok || err != nil {
+				return data, ok, err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false, nil
+		case data := <-t.queue:
+			return data, true, nil
+		case <-spilled:
+		}
+	}
+}
+
+// run writes queued notifications at the configured rate until ctx is done
+// or write fails.
+func (t *notificationThrottle) run(ctx context.Context, write func([]byte) error) error {
+	if t.spill != nil {
+		defer t.spill.close()
+	}
+	for {
+		data, ok, err := t.next(ctx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := t.bucket.wait(ctx); err != nil {
+			return nil
+		}
+		if err := write(data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package bridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/config"
+)
+
+// RecordingVersion is the version of the session recording format.
+const RecordingVersion = 1
+
+// Recording event kinds.
+const (
+	// RecordRequest is a message read from stdin.
+	RecordRequest = "request"
+	// RecordResponse is a message written to the client: a server
+	// message, or an error response from the bridge.
+	RecordResponse = "response"
+)
+
+// RecordingHeader is the first line of a session recording.
+type RecordingHeader struct {
+	Version   int           `json:"version"`
+	Started   time.Time     `json:"started"`
+	Server    string        `json:"server"` // Server URL without credentials or query
+	Transport TransportType `json:"transport"`
+}
+
+// RecordedEvent is a message in a session recording.
+type RecordedEvent struct {
+	Kind    string          `json:"kind"`
+	Offset  time.Duration   `json:"offset_ns"` // Time since the session started
+	Message json.RawMessage `json:"message"`
+}
+
+// Recording is a recorded session.
+type Recording struct {
+	Header RecordingHeader
+	Events []RecordedEvent
+}
+
+// Recorder writes a session recording as JSON lines: a RecordingHeader
+// followed by one RecordedEvent per message.
+type Recorder struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+	err   error
+}
+
+// NewRecorder starts a recording to w of a session with serverURL over
+// transportType, writing the header.
+func NewRecorder(w io.Writer, serverURL string, transportType TransportType) (*Recorder, error) {
+	r := &Recorder{enc: json.NewEncoder(w), start: time.Now()}
+	header := RecordingHeader{
+		Version:   RecordingVersion,
+		Started:   r.start.UTC(),
+		Server:    config.RedactURL(serverURL),
+		Transport: transportType,
+	}
+	if err := r.enc.Encode(header); err != nil {
+		return nil, fmt.Errorf("failed to write recording header: %w", err)
+	}
+	return r, nil
+}
+
+// record appends a message to the recording. After a write error, later
+// messages are not recorded.
+func (r *Recorder) record(kind string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = r.enc.Encode(RecordedEvent{
+		Kind:    kind,
+		Offset:  time.Since(r.start),
+		Message: json.RawMessage(data),
+	})
+}
+
+// Err returns the first error writing the recording, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// SetRecorder records the session's stdin messages and the messages written
+// to the client to r. It must be called before Run.
+func (b *Bridge) SetRecorder(r *Recorder) {
+	b.recorder = r
+}
+
+// ReadRecording reads a session recording written by a Recorder.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 2*MaxMessageSize)
+
+	rec := &Recording{}
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("empty recording")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &rec.Header); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	if rec.Header.Version != RecordingVersion {
+		return nil, fmt.Errorf("unsupported recording version %d", rec.Header.Version)
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		var event RecordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("invalid recording event on line %d: %w", line, err)
+		}
+		rec.Events = append(rec.Events, event)
+	}
+	return rec, scanner.Err()
+}
+
+// NewReplayReader returns a reader yielding the recorded requests as
+// newline-delimited stdin lines, each at its original offset from when the
+// reader was created. It reports EOF after the last request.
+func NewReplayReader(rec *Recording) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		start := time.Now()
+		for _, event := range rec.Events {
+			if event.Kind != RecordRequest {
+				continue
+			}
+			time.Sleep(time.Until(start.Add(event.Offset)))
+			if _, err := pw.Write(append(event.Message, '\n')); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	return pr
+}
@@ -0,0 +1,39 @@
+package bridge
+
+import (
+	"context"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// ShutdownStep is one step of the sequence run once the bridge has stopped,
+// such as finishing a recording or flushing traces. Name completes "Failed
+// to ..." in the warning logged if the step fails.
+type ShutdownStep struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Shutdown runs steps in order, giving each up to timeout. A step that
+// fails or overruns is logged and abandoned, and the next one runs anyway,
+// so a stalled sink cannot lose the output of later steps or keep the
+// process from exiting.
+func Shutdown(steps []ShutdownStep, timeout time.Duration, logger *logging.Logger) {
+	for _, step := range steps {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		done := make(chan error, 1)
+		go func() { done <- step.Run(ctx) }()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		cancel()
+		if err != nil {
+			logger.Warn("Failed to %s: %v", step.Name, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errNoSingleShotRequest is returned in single-shot mode when stdin closes
+// before a request is read.
+var errNoSingleShotRequest = errors.New("stdin closed before a request was read")
+
+// singleShot tracks the one request forwarded in single-shot mode. Exactly
+// one response to it is written: the first one claimed, by the server or by
+// the timeout, under mu.
+type singleShot struct {
+	mu       sync.Mutex
+	id       []byte // Compact JSON of the request id, nil until one is read
+	rawID    any
+	method   string
+	timeout  time.Duration
+	answered bool          // A response has been claimed
+	sent     chan struct{} // Closed once the request is read
+	done     chan struct{} // Closed once its response is written
+	err      error         // The response's error, if any
+}
+
+func newSingleShot() *singleShot {
+	return &singleShot{sent: make(chan struct{}), done: make(chan struct{})}
+}
+
+// start records the request. It must be called before the request is
+// forwarded, since the response may be written before forwarding returns.
+func (s *singleShot) start(id any, method string, timeout time.Duration) {
+	data, _ := json.Marshal(id)
+	s.mu.Lock()
+	s.id, s.rawID, s.method, s.timeout = data, id, method, timeout
+	s.mu.Unlock()
+	close(s.sent)
+}
+
+// started reports whether the request has been read.
+func (s *singleShot) started() bool {
+	select {
+	case <-s.sent:
+		return true
+	default:
+		return false
+	}
+}
+
+// claim is called with each message about to be written to stdout. It
+// reports whether data is the response to the request, and whether it may
+// be written: a response after the first one claimed may not.
+func (s *singleShot) claim(data []byte) (answer, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id == nil {
+		return false, true
+	}
+	var resp struct {
+		ID    json.RawMessage `json:"id"`
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil || len(resp.ID) == 0 {
+		return false, true
+	}
+	var id bytes.Buffer
+	if json.Compact(&id, resp.ID) != nil || !bytes.Equal(id.Bytes(), s.id) {
+		return false, true
+	}
+	if s.answered {
+		return true, false
+	}
+	s.answered = true
+	if resp.Error != nil {
+		s.err = fmt.Errorf("%s failed: %s (code %d)", s.method, resp.Error.Message, resp.Error.Code)
+	}
+	return true, true
+}
+
+// expire claims the response for a timeout with err, unless one was
+// already claimed.
+func (s *singleShot) expire(err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.answered {
+		return false
+	}
+	s.answered, s.err = true, err
+	return true
+}
+
+// finish marks the claimed response as written.
+func (s *singleShot) finish() {
+	close(s.done)
+}
+
+// result returns the response's error, if any.
+func (s *singleShot) result() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// awaitSingleShot waits for the response to the single-shot request, up to
+// its request timeout, and returns the error to exit with: nil for a result,
+// or the response's error. It returns nil if ctx is done first.
+func (b *Bridge) awaitSingleShot(ctx context.Context) error {
+	s := b.shot
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-s.sent:
+	}
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-s.done:
+	case <-timer.C:
+		b.expireSingleShot(fmt.Sprintf("no response to %s within %s", s.method, s.timeout))
+	}
+	if err := s.result(); err != nil {
+		return err
+	}
+	b.logger.Info("Single-shot response written, shutting down bridge")
+	return nil
+}
+
+// expireSingleShot answers the single-shot request with a timeout error,
+// unless its response has been written. Holding stdoutMu keeps a response
+// from being written between the decision and the error.
+func (b *Bridge) expireSingleShot(message string) {
+	s := b.shot
+	b.stdoutMu.Lock()
+	defer b.stdoutMu.Unlock()
+	if !s.expire(WrapError(ErrTimeout, message)) {
+		return
+	}
+	message = "request timeout: " + message
+	b.errorLog.record(errorOriginBridge, s.rawID, s.method, CodeServerError, message)
+	if b.writeStdoutLocked(errorResponse(s.rawID, CodeServerError, message)) == nil {
+		s.finish()
+	}
+}
@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// errSpillFull is returned by spillFile.push when the message does not fit.
+var errSpillFull = errors.New("spill file is full")
+
+// spillFile is a first-in first-out queue of messages kept in a temporary
+// file, each stored as a 4-byte big-endian length and the message. The file
+// is created on first use and emptied whenever every message has been read,
+// and never grows beyond max bytes.
+type spillFile struct {
+	mu       sync.Mutex
+	max      int64
+	file     *os.File
+	readOff  int64
+	writeOff int64
+	closed   bool
+	ready    chan struct{} // Signalled when a message is pushed
+}
+
+// newSpillFile creates a spill queue holding at most max bytes.
+func newSpillFile(max int64) *spillFile {
+	return &spillFile{max: max, ready: make(chan struct{}, 1)}
+}
+
+// pending reports whether there are unread messages.
+func (s *spillFile) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readOff < s.writeOff
+}
+
+// push appends data to the queue.
+func (s *spillFile) push(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return os.ErrClosed
+	}
+	size := int64(4 + len(data))
+	if s.writeOff+size > s.max {
+		return errSpillFull
+	}
+	if s.file == nil {
+		f, err := os.CreateTemp("", "mcp-over-socks-spill-*")
+		if err != nil {
+			return err
+		}
+		s.file = f
+	}
+
+	record := make([]byte, size)
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], data)
+	if _, err := s.file.WriteAt(record, s.writeOff); err != nil {
+		return err
+	}
+	s.writeOff += size
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pop removes and returns the oldest message, or reports false if the queue
+// is empty.
+func (s *spillFile) pop() ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOff == s.writeOff {
+		return nil, false, nil
+	}
+
+	var length [4]byte
+	if _, err := s.file.ReadAt(length[:], s.readOff); err != nil {
+		return nil, false, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := s.file.ReadAt(data, s.readOff+4); err != nil {
+		return nil, false, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	s.readOff += int64(4 + len(data))
+
+	// Start over once drained, so the file only holds the current backlog
+	if s.readOff == s.writeOff {
+		s.readOff, s.writeOff = 0, 0
+		if err := s.file.Truncate(0); err != nil {
+			return nil, false, fmt.Errorf("failed to truncate spill file: %w", err)
+		}
+	}
+	return data, true, nil
+}
+
+// close removes the spill file, discarding unread messages.
+func (s *spillFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	err := os.Remove(s.file.Name())
+	s.file = nil
+	s.readOff, s.writeOff = 0, 0
+	return err
+}
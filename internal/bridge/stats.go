@@ -0,0 +1,108 @@
+package bridge
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the bridge's session counters.
+// All counters are safe for concurrent use.
+type Stats struct {
+	messagesSent     atomic.Int64
+	messagesReceived atomic.Int64
+	bytesSent        atomic.Int64
+	bytesReceived    atomic.Int64
+	errors           atomic.Int64
+
+	mu              sync.Mutex
+	startedAt       time.Time
+	stoppedAt       time.Time
+	protocolVersion string
+}
+
+// StatsSnapshot is a point-in-time copy of the session counters.
+type StatsSnapshot struct {
+	MessagesSent     int64   `json:"messages_sent"`
+	MessagesReceived int64   `json:"messages_received"`
+	BytesSent        int64   `json:"bytes_sent"`
+	BytesReceived    int64   `json:"bytes_received"`
+	Errors           int64   `json:"errors"`
+	DurationSeconds  float64 `json:"duration_seconds"`
+	ProtocolVersion  string  `json:"protocol_version,omitempty"`
+}
+
+// start records the beginning of the session.
+func (s *Stats) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startedAt = time.Now()
+	s.stoppedAt = time.Time{}
+}
+
+// stop records the end of the session.
+func (s *Stats) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stoppedAt = time.Now()
+}
+
+// recordSent counts a message forwarded to the server.
+func (s *Stats) recordSent(size int) {
+	s.messagesSent.Add(1)
+	s.bytesSent.Add(int64(size))
+}
+
+// recordReceived counts a message forwarded to the client.
+func (s *Stats) recordReceived(size int) {
+	s.messagesReceived.Add(1)
+	s.bytesReceived.Add(int64(size))
+}
+
+// recordProtocolVersion records the MCP protocol version negotiated in initialize.
+func (s *Stats) recordProtocolVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protocolVersion = version
+}
+
+// recordError counts a failed or dropped message.
+func (s *Stats) recordError() {
+	s.errors.Add(1)
+}
+
+// Snapshot returns the current counter values.
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	var duration time.Duration
+	switch {
+	case s.startedAt.IsZero():
+	case s.stoppedAt.IsZero():
+		duration = time.Since(s.startedAt)
+	default:
+		duration = s.stoppedAt.Sub(s.startedAt)
+	}
+	protocolVersion := s.protocolVersion
+	s.mu.Unlock()
+
+	return StatsSnapshot{
+		MessagesSent:     s.messagesSent.Load(),
+		MessagesReceived: s.messagesReceived.Load(),
+		BytesSent:        s.bytesSent.Load(),
+		BytesReceived:    s.bytesReceived.Load(),
+		Errors:           s.errors.Load(),
+		DurationSeconds:  duration.Seconds(),
+		ProtocolVersion:  protocolVersion,
+	}
+}
+
+// WriteFile writes the current snapshot to path as JSON.
+func (s *Stats) WriteFile(path string) error {
+	data, err := json.MarshalIndent(s.Snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
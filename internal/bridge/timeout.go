@@ -0,0 +1,112 @@
+package bridge
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/config"
+)
+
+// requestMethodKey is the context key for the JSON-RPC method of the request
+// being sent.
+type requestMethodKey struct{}
+
+// withRequestMethod returns a copy of ctx recording the JSON-RPC method sent
+// with it, for methodTimeoutTransport.
+func withRequestMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, requestMethodKey{}, method)
+}
+
+// methodTimeoutTransport bounds each request, including reading its
+// response body, by the timeout for its JSON-RPC method, or by the client's
+// own timeout for other requests.
+type methodTimeoutTransport struct {
+	base     http.RoundTripper
+	timeouts []config.MethodTimeout
+	fallback time.Duration // 0 for no limit
+}
+
+// withMethodTimeouts returns a copy of client that applies timeouts to
+// requests for matching methods in place of the client's Timeout.
+func withMethodTimeouts(client *http.Client, timeouts []config.MethodTimeout) *http.Client {
+	c := *client
+	c.Timeout = 0
+	c.Transport = &methodTimeoutTransport{base: client.Transport, timeouts: timeouts, fallback: client.Timeout}
+	return &c
+}
+
+// timeoutFor returns the timeout for method, or the fallback if no override
+// matches.
+func (t *methodTimeoutTransport) timeoutFor(method string) time.Duration {
+	if timeout, ok := matchMethodTimeout(t.timeouts, method); ok {
+		return timeout
+	}
+	return t.fallback
+}
+
+// matchMethodTimeout returns the timeout of the first override matching
+// method exactly, else of the first matching glob.
+func matchMethodTimeout(timeouts []config.MethodTimeout, method string) (time.Duration, bool) {
+	if method == "" {
+		return 0, false
+	}
+	for _, mt := range timeouts {
+		if mt.Pattern == method {
+			return mt.Timeout, true
+		}
+	}
+	for _, mt := range timeouts {
+		if ok, _ := path.Match(mt.Pattern, method); ok {
+			return mt.Timeout, true
+		}
+	}
+	return 0, false
+}
+
+// requestTimeout returns the request timeout for method.
+func (b *Bridge) requestTimeout(method string) time.Duration {
+	timeouts, _ := b.config.MethodTimeoutList()
+	if timeout, ok := matchMethodTimeout(timeouts, method); ok {
+		return timeout
+	}
+	return b.config.Timeout
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *methodTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	method, _ := req.Context().Value(requestMethodKey{}).(string)
+	timeout := t.timeoutFor(method)
+	if timeout <= 0 {
+		return base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The deadline also covers the body, which may be a long SSE stream
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's context when its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer.
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// tlsDebugTransport logs the negotiated TLS parameters of each new
+// connection to the server at debug level.
+type tlsDebugTransport struct {
+	base   http.RoundTripper
+	logger *logging.Logger
+}
+
+// withTLSDebug returns a copy of client that logs TLS details of new
+// connections.
+func withTLSDebug(client *http.Client, logger *logging.Logger) *http.Client {
+	c := *client
+	c.Transport = &tlsDebugTransport{base: client.Transport, logger: logger}
+	return &c
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *tlsDebugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	host := req.URL.Host
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			// Connections are handshaken before they are handed out, so
+			// only new ones need reporting
+			if info.Reused {
+				return
+			}
+			if conn, ok := info.Conn.(*tls.Conn); ok {
+				t.logger.Debug("TLS connection to %s: %s", host, describeTLS(conn.ConnectionState()))
+			}
+		},
+	}
+	return base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// describeTLS summarizes the version, cipher suite, ALPN protocol and peer
+// certificate subject of a TLS connection.
+func describeTLS(state tls.ConnectionState) string {
+	desc := tls.VersionName(state.Version) + ", cipher " + tls.CipherSuiteName(state.CipherSuite)
+	if state.NegotiatedProtocol != "" {
+		desc += ", ALPN " + state.NegotiatedProtocol
+	}
+	if state.DidResume {
+		desc += ", resumed"
+	}
+	if len(state.PeerCertificates) > 0 {
+		desc += ", peer " + state.PeerCertificates[0].Subject.String()
+	}
+	return desc
+}
@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/google/jsonschema-go/jsonschema"
+)
+
+// jsonSchemaDraft is the only JSON Schema version the validator supports.
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// toolSchemas caches tool input schemas from tools/list results, for
+// validating tools/call arguments before they are forwarded (--validate-params).
+type toolSchemas struct {
+	mu      sync.Mutex
+	schemas map[string]*jsonschema.Resolved
+}
+
+// newToolSchemas creates an empty toolSchemas.
+func newToolSchemas() *toolSchemas {
+	return &toolSchemas{schemas: make(map[string]*jsonschema.Resolved)}
+}
+
+// update caches the input schemas from a tools/list result. Pages of a
+// paginated listing add to the cache. It returns the names of tools whose
+// schemas could not be used; their calls are not validated.
+func (c *toolSchemas) update(result json.RawMessage) (skipped []string) {
+	var list struct {
+		Tools []struct {
+			Name        string             `json:"name"`
+			InputSchema *jsonschema.Schema `json:"inputSchema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(result, &list); err != nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tool := range list.Tools {
+		delete(c.schemas, tool.Name)
+		if tool.InputSchema == nil {
+			continue
+		}
+		if v := tool.InputSchema.Schema; v != "" && v != jsonSchemaDraft {
+			skipped = append(skipped, tool.Name)
+			continue
+		}
+		resolved, err := tool.InputSchema.Resolve(nil)
+		if err != nil {
+			skipped = append(skipped, tool.Name)
+			continue
+		}
+		c.schemas[tool.Name] = resolved
+	}
+	return skipped
+}
+
+// validate checks tools/call params against the cached schema of the called
+// tool. Calls to tools without a cached schema pass.
+func (c *toolSchemas) validate(params json.RawMessage) error {
+	var call struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &call); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	schema, ok := c.schemas[call.Name]
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if call.Arguments == nil {
+		call.Arguments = map[string]any{}
+	}
+	return schema.Validate(call.Arguments)
+}
@@ -2,18 +2,75 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"net"
+	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Stdio message framings.
+const (
+	// FramingLine delimits messages with newlines (the MCP stdio transport).
+	FramingLine = "line"
+	// FramingHeader prefixes messages with LSP-style Content-Length headers.
+	FramingHeader = "header"
+)
+
+// Policies for requests that reuse the id of an in-flight request.
+const (
+	// DuplicateIDAllow forwards the request anyway.
+	DuplicateIDAllow = "allow"
+	// DuplicateIDReject answers the request with an error.
+	DuplicateIDReject = "reject"
+)
+
+// Policies for SSE events whose data field is present but empty.
+const (
+	// SSEEmptyDataDrop consumes the event.
+	SSEEmptyDataDrop = "drop"
+	// SSEEmptyDataForward passes the event to the client as a keepalive
+	// notification.
+	SSEEmptyDataForward = "forward"
+)
+
+// MCP spec revisions selectable with --compat.
+const (
+	// CompatSSE is the 2024-11-05 HTTP+SSE transport: a GET event stream
+	// that announces the POST endpoint in an endpoint event.
+	CompatSSE = "2024-11-05"
+	// CompatStreamable is the 2025-03-26 Streamable HTTP transport.
+	CompatStreamable = "2025-03-26"
+)
+
+// Policies for SSE events over --max-event-data-bytes.
+const (
+	// OversizedEventReject drops the event, answering a response with an
+	// error for its id.
+	OversizedEventReject = "reject"
+	// OversizedEventTruncate abbreviates long string values in the message
+	// until it fits.
+	OversizedEventTruncate = "truncate"
+	// OversizedEventSplit forwards each message of a batch as its own event,
+	// and rejects events that cannot be split.
+	OversizedEventSplit = "split"
+)
+
 // Config holds the configuration for the bridge.
 type Config struct {
 	// ProxyAddr is the SOCKS5 proxy address.
 	// Supported schemes:
 	//   - socks5://  - Local DNS resolution (resolve hostname locally before connecting)
 	//   - socks5h:// - Remote DNS resolution (let the proxy server resolve the hostname)
+	//   - socks5s://, socks5hs:// - As above, with SOCKS5 spoken over TLS to the proxy
 	ProxyAddr string
 
 	// ServerURL is the remote MCP server URL (e.g., "http://remote:8080/sse").
@@ -22,15 +79,237 @@ type Config struct {
 	// Timeout is the HTTP request timeout.
 	Timeout time.Duration
 
-	// LogLevel is the logging verbosity ("debug", "info", "error").
+	// LogLevel is the logging verbosity ("debug", "info", "warn", "error").
 	LogLevel string
+
+	// ResolveOverrides pins hostnames to fixed IP addresses, in curl's
+	// --resolve format ("host:port:ip").
+	ResolveOverrides []string
+
+	// ClientPID is the local MCP client's process ID, sent upstream for audit
+	// when non-zero.
+	ClientPID int
+
+	// ClientName is the local MCP client's name, sent upstream for audit
+	// when non-empty.
+	ClientName string
+
+	// MetricsFile, if set, is the path where a JSON snapshot of the session
+	// counters is written when the bridge exits.
+	MetricsFile string
+
+	// ProtocolVersion, if set, is sent as the MCP-Protocol-Version header on
+	// every request (e.g., "2025-03-26"). If empty, the version negotiated by
+	// initialize is sent on the requests that follow it.
+	ProtocolVersion string
+
+	// WarnMessageBytes, if positive, logs a warning for stdin messages larger
+	// than this many bytes, before they reach the hard message size limit.
+	WarnMessageBytes int
+
+	// ServerHeaders are extra headers sent on every request to the server,
+	// in "Name: Value" form.
+	ServerHeaders []string
+
+	// AllowInsecureAuth permits sending credential-bearing ServerHeaders over
+	// plain http://, where the proxy operator could read them.
+	AllowInsecureAuth bool
+
+	// ErrorLogFile, if set, is the path where every JSON-RPC error response
+	// sent to the client is appended.
+	ErrorLogFile string
+
+	// LogSyslog sends logs to syslog instead of stderr.
+	LogSyslog bool
+
+	// SyslogAddr is the remote syslog address ("[network://]host:port").
+	// If empty, the local syslog daemon is used.
+	SyslogAddr string
+
+	// ServerTLSMinVersion is the minimum TLS version for https:// servers
+	// ("1.2" or "1.3"). If empty, Go's default (TLS 1.2) applies.
+	ServerTLSMinVersion string
+
+	// MaxLineRate, if positive, limits server notifications written to
+	// stdout to this many per second. Responses are never throttled.
+	MaxLineRate float64
+
+	// AssumeProxyLocalhost acknowledges that a loopback server URL with
+	// remote DNS means the proxy host's loopback, suppressing the warning.
+	AssumeProxyLocalhost bool
+
+	// NormalizeEnvelope fixes a missing or wrong "jsonrpc" version in server
+	// messages, which the SDK would otherwise reject, with a warning.
+	NormalizeEnvelope bool
+
+	// Strict replaces server responses with a malformed JSON-RPC envelope
+	// with an error response, instead of normalizing them.
+	Strict bool
+
+	// WarmUp sends a benign request after connecting, so the first client
+	// request reuses an established tunnel.
+	WarmUp bool
+
+	// SSEPingEvent is the name of SSE keepalive events, which are consumed
+	// instead of being decoded as messages. Empty disables the filter.
+	SSEPingEvent string
+
+	// SSEEmptyData is how SSE events with empty data (such as "data:" on its
+	// own, which some servers send as a keepalive) are handled:
+	// SSEEmptyDataDrop consumes them, and SSEEmptyDataForward passes each to
+	// the client as a "notifications/sse_keepalive" notification. Either
+	// way they count as reads on the connection, so they keep
+	// SocketIOTimeout from expiring.
+	SSEEmptyData string
+
+	// DisableCapabilities is a comma-separated list of client capabilities
+	// removed from the initialize request before it reaches the server.
+	DisableCapabilities string
+
+	// NotificationsFD is an inherited file descriptor, 3 or above, that
+	// receives server notifications instead of stdout (0 means stdout).
+	NotificationsFD int
+
+	// TLSSessionCache enables TLS session resumption for https:// servers,
+	// saving a full handshake on reconnects.
+	TLSSessionCache bool
+
+	// Framing is the stdio message framing: FramingLine or FramingHeader.
+	Framing string
+
+	// ServerPins are SHA-256 fingerprints of accepted server leaf
+	// certificates ("sha256:<hex|base64>"); any one must match.
+	ServerPins []string
+
+	// SocketIOTimeout bounds each read and write on proxied connections.
+	// It must exceed the server's longest idle period (e.g. SSE keepalives).
+	SocketIOTimeout time.Duration
+
+	// ProxyCAFile is a PEM CA bundle for verifying a TLS proxy; empty uses
+	// the system roots.
+	ProxyCAFile string
+
+	// ProxyServerName overrides the server name verified for a TLS proxy.
+	ProxyServerName string
+
+	// ServerCADir is a directory of *.pem/*.crt CA files trusted for
+	// https:// servers instead of the system roots.
+	ServerCADir string
+
+	// WarnProtocolMismatch logs a warning when the protocol version returned
+	// by initialize differs from the one the client requested.
+	WarnProtocolMismatch bool
+
+	// ValidateParams rejects tools/call requests whose arguments violate the
+	// tool's input schema from a previous tools/list, without forwarding them.
+	ValidateParams bool
+
+	// CancelOnSignal sends notifications/cancelled for in-flight requests
+	// when the bridge is shut down by its caller (SIGINT/SIGTERM).
+	CancelOnSignal bool
+
+	// Discover reads /.well-known/mcp from the server to choose the transport
+	// and endpoint before falling back to URL-based detection.
+	Discover bool
+
+	// HandleLocally is a comma-separated list of methods answered by the
+	// bridge's built-in handlers instead of being forwarded to the server.
+	HandleLocally string
+
+	// OTLPEndpoint, if set, is the OTLP/HTTP collector URL that receives a
+	// trace span per forwarded request.
+	OTLPEndpoint string
+
+	// Compat pins the transport defined by an MCP spec revision: CompatSSE
+	// or CompatStreamable. Empty leaves the choice to --transport.
+	Compat string
+
+	// OnDuplicateID is how requests reusing the id of an in-flight request
+	// are handled: DuplicateIDAllow or DuplicateIDReject.
+	OnDuplicateID string
+
+	// HeadersFile, if set, is a file of "Name: Value" lines loaded into
+	// ServerHeaders by LoadHeadersFile.
+	HeadersFile string
+
+	// StdinTCP, if set, is a TCP address whose accepted connections replace
+	// stdin/stdout, one client at a time with a fresh server connection each.
+	StdinTCP string
+
+	// LogMaxString, if positive, abbreviates JSON string values longer than
+	// this many bytes in debug logs. Forwarded messages are not affected.
+	LogMaxString int
+
+	// EventWebhook, if set, is a URL that connection lifecycle events are
+	// POSTed to as JSON.
+	EventWebhook string
+
+	// EventWebhookViaProxy sends webhook events through the SOCKS proxy
+	// rather than directly.
+	EventWebhookViaProxy bool
+
+	// NoBatch rejects stdin messages whose top-level value is an array
+	// (a JSON-RPC batch) with an Invalid Request error.
+	NoBatch bool
+
+	// MaxEventDataBytes, if positive, limits the joined data of one SSE
+	// event; larger events are handled according to OversizedEvent.
+	MaxEventDataBytes int
+
+	// OversizedEvent is how SSE events over MaxEventDataBytes are handled:
+	// OversizedEventReject, OversizedEventTruncate or OversizedEventSplit.
+	OversizedEvent string
+
+	// MaxConcurrentDials, if positive, bounds the dials to the proxy in
+	// flight at once.
+	MaxConcurrentDials int
+
+	// ProxyRulesFile, if set, is a file of "pattern -> target" lines that
+	// choose the proxy per destination host; see LoadProxyRules.
+	ProxyRulesFile string
+
+	// MaxClientResponseBytes, if positive, is the largest response written
+	// to stdout; larger ones are replaced with an error for the same id.
+	MaxClientResponseBytes int
+
+	// RecordFile, if set, is the path a session recording is written to
+	// (see bridge.Recorder).
+	RecordFile string
+
+	// MaxHeaderBytes, if positive, limits the size of server response
+	// headers.
+	MaxHeaderBytes int64
+
+	// Mirror, if set, is a file or Unix socket path that server messages
+	// are copied to, best-effort, in addition to stdout.
+	Mirror string
+
+	// NotificationSpillBytes, if positive, keeps notifications overflowing
+	// the MaxLineRate buffer in a temporary file of up to this size until
+	// stdout catches up, instead of dropping them.
+	NotificationSpillBytes int64
+
+	// SingleShot forwards only the first request read from stdin and ends
+	// the session once its response is written.
+	SingleShot bool
+
+	// MethodTimeouts override Timeout for requests whose JSON-RPC method
+	// matches, as "method=duration" entries; see MethodTimeoutList.
+	MethodTimeouts []string
 }
 
 // DefaultConfig returns a Config with default values.
 func DefaultConfig() *Config {
 	return &Config{
-		Timeout:  30 * time.Second,
-		LogLevel: "info",
+		Timeout:           30 * time.Second,
+		LogLevel:          "info",
+		SSEPingEvent:      "ping",
+		SSEEmptyData:      SSEEmptyDataDrop,
+		TLSSessionCache:   true,
+		NormalizeEnvelope: true,
+		Framing:           FramingLine,
+		OnDuplicateID:     DuplicateIDAllow,
+		OversizedEvent:    OversizedEventReject,
 	}
 }
 
@@ -40,17 +319,8 @@ func (c *Config) Validate() error {
 		return errors.New("proxy address is required (use --proxy)")
 	}
 
-	if !strings.HasPrefix(c.ProxyAddr, "socks5://") && !strings.HasPrefix(c.ProxyAddr, "socks5h://") {
-		return errors.New("proxy address must start with socks5:// or socks5h://")
-	}
-
-	// Validate proxy URL format
-	proxyURL, err := url.Parse(c.ProxyAddr)
-	if err != nil {
-		return errors.New("invalid proxy address format: " + err.Error())
-	}
-	if proxyURL.Host == "" {
-		return errors.New("proxy address must include host")
+	if err := validateProxyAddr(c.ProxyAddr); err != nil {
+		return err
 	}
 
 	if c.ServerURL == "" {
@@ -74,15 +344,453 @@ func (c *Config) Validate() error {
 		return errors.New("timeout must be positive")
 	}
 
+	if _, err := c.ResolveMap(); err != nil {
+		return err
+	}
+
+	if _, err := c.MethodTimeoutList(); err != nil {
+		return err
+	}
+
+	headers, err := c.ServerHeaderMap()
+	if err != nil {
+		return err
+	}
+	if serverURL.Scheme == "http" && !c.AllowInsecureAuth {
+		for name := range headers {
+			if IsSensitiveHeader(name) {
+				return errors.New("refusing to send credential header '" + name + "' over plain http:// through the proxy, " +
+					"where the proxy operator could read it; use https:// or pass --allow-insecure-http-server-with-auth")
+			}
+		}
+	}
+
+	if _, err := c.TLSMinVersion(); err != nil {
+		return err
+	}
+
+	if _, err := c.ServerPinHashes(); err != nil {
+		return err
+	}
+
+	if !c.IsProxyTLS() && (c.ProxyCAFile != "" || c.ProxyServerName != "") {
+		return errors.New("proxy CA and server name require a TLS proxy (socks5s:// or socks5hs://)")
+	}
+
+	if c.SocketIOTimeout < 0 {
+		return errors.New("socket I/O timeout must not be negative")
+	}
+
+	if c.MaxLineRate < 0 {
+		return errors.New("max line rate must not be negative")
+	}
+
+	if c.NotificationSpillBytes < 0 {
+		return errors.New("notification spill size must not be negative")
+	}
+	if c.NotificationSpillBytes > 0 && c.MaxLineRate == 0 {
+		return errors.New("notification spill requires --max-line-rate")
+	}
+
+	if c.SyslogAddr != "" && !c.LogSyslog {
+		return errors.New("syslog address requires --log-syslog")
+	}
+
+	switch c.Framing {
+	case "", FramingLine, FramingHeader:
+	default:
+		return errors.New("framing must be " + FramingLine + " or " + FramingHeader)
+	}
+
+	if c.StdinTCP != "" {
+		if _, _, err := net.SplitHostPort(c.StdinTCP); err != nil {
+			return errors.New("stdin TCP address must be host:port or :port")
+		}
+		if c.SingleShot {
+			return errors.New("single-shot mode cannot be combined with --stdin-tcp")
+		}
+	}
+
+	switch c.OnDuplicateID {
+	case "", DuplicateIDAllow, DuplicateIDReject:
+	default:
+		return errors.New("duplicate id policy must be " + DuplicateIDAllow + " or " + DuplicateIDReject)
+	}
+
+	switch c.Compat {
+	case "", CompatSSE, CompatStreamable:
+	default:
+		return errors.New("compat must be " + CompatSSE + " or " + CompatStreamable)
+	}
+
+	if c.EventWebhook != "" {
+		u, err := url.Parse(c.EventWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("event webhook must be an http:// or https:// URL")
+		}
+	}
+
+	if c.MaxHeaderBytes < 0 {
+		return errors.New("max header bytes must not be negative")
+	}
+
+	if c.MaxClientResponseBytes < 0 {
+		return errors.New("max client response bytes must not be negative")
+	}
+
+	if c.MaxConcurrentDials < 0 {
+		return errors.New("max concurrent dials must not be negative")
+	}
+
+	if c.MaxEventDataBytes < 0 {
+		return errors.New("max event data bytes must not be negative")
+	}
+
+	switch c.SSEEmptyData {
+	case "", SSEEmptyDataDrop, SSEEmptyDataForward:
+	default:
+		return errors.New("SSE empty data policy must be " + SSEEmptyDataDrop + " or " + SSEEmptyDataForward)
+	}
+
+	switch c.OversizedEvent {
+	case "", OversizedEventReject, OversizedEventTruncate, OversizedEventSplit:
+	default:
+		return errors.New("oversized event policy must be " + OversizedEventReject + ", " + OversizedEventTruncate + " or " + OversizedEventSplit)
+	}
+
+	if c.LogMaxString < 0 {
+		return errors.New("log string limit must not be negative")
+	}
+
+	if c.WarnMessageBytes < 0 {
+		return errors.New("message size warning threshold must not be negative")
+	}
+
+	if c.NotificationsFD < 0 || c.NotificationsFD == 1 || c.NotificationsFD == 2 {
+		return errors.New("notifications fd must be 3 or above (0-2 are the standard streams)")
+	}
+
+	if c.ProtocolVersion != "" {
+		if _, err := time.Parse("2006-01-02", c.ProtocolVersion); err != nil {
+			return errors.New("protocol version must be a date like 2025-03-26")
+		}
+	}
+
 	return nil
 }
 
-// ProxyHost returns the proxy host:port from the ProxyAddr.
+// ResolveMap parses ResolveOverrides into a map of "host:port" to IP address.
+func (c *Config) ResolveMap() (map[string]string, error) {
+	overrides := make(map[string]string, len(c.ResolveOverrides))
+	for _, entry := range c.ResolveOverrides {
+		// The IP may itself contain colons (IPv6), so split on the first two only.
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("invalid resolve override '" + entry + "' (expected host:port:ip)")
+		}
+		ip := strings.Trim(parts[2], "[]")
+		if net.ParseIP(ip) == nil {
+			return nil, errors.New("invalid IP address in resolve override '" + entry + "'")
+		}
+		overrides[net.JoinHostPort(parts[0], parts[1])] = ip
+	}
+	return overrides, nil
+}
+
+// MethodTimeout is the request timeout for JSON-RPC methods matching Pattern.
+type MethodTimeout struct {
+	Pattern string
+	Timeout time.Duration
+}
+
+// MethodTimeoutList parses MethodTimeouts. A pattern is an exact method name
+// or a glob as in path.Match, so "tools/*" matches "tools/call" but "*" does
+// not cross a "/".
+func (c *Config) MethodTimeoutList() ([]MethodTimeout, error) {
+	timeouts := make([]MethodTimeout, 0, len(c.MethodTimeouts))
+	for _, entry := range c.MethodTimeouts {
+		pattern, value, ok := strings.Cut(entry, "=")
+		pattern = strings.TrimSpace(pattern)
+		if !ok || pattern == "" {
+			return nil, errors.New("invalid method timeout '" + entry + "' (expected method=duration)")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("invalid method pattern in method timeout '" + entry + "'")
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			return nil, errors.New("method timeout must be a positive duration in '" + entry + "'")
+		}
+		timeouts = append(timeouts, MethodTimeout{Pattern: pattern, Timeout: timeout})
+	}
+	return timeouts, nil
+}
+
+// Warnings returns non-fatal configuration problems worth reporting to the user.
+// Call it after Validate succeeds.
+func (c *Config) Warnings() []string {
+	var warnings []string
+
+	if c.IsRemoteDNS() && !c.AssumeProxyLocalhost {
+		if u, err := url.Parse(c.ServerURL); err == nil && isLoopbackHost(u.Hostname()) {
+			warnings = append(warnings, "server host '"+u.Hostname()+"' is a loopback address, and with socks5h:// "+
+				"it is resolved by the proxy: this connects to the proxy host's own loopback, not this machine's "+
+				"(pass --assume-proxy-localhost if that is intended)")
+		}
+	}
+
+	if c.StdinTCP != "" {
+		if host, _, err := net.SplitHostPort(c.StdinTCP); err == nil && !isLoopbackHost(host) {
+			warnings = append(warnings, "stdin TCP address '"+c.StdinTCP+"' is not a loopback address: "+
+				"any host that can reach it can use the bridge, without authentication "+
+				"(use 127.0.0.1:port to accept local clients only)")
+		}
+	}
+
+	return warnings
+}
+
+// isLoopbackHost reports whether host names the loopback interface.
+func isLoopbackHost(host string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// TLSMinVersion returns the tls.Config.MinVersion value for ServerTLSMinVersion,
+// or 0 if unset.
+func (c *Config) TLSMinVersion() (uint16, error) {
+	switch c.ServerTLSMinVersion {
+	case "":
+		return 0, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, errors.New("server TLS minimum version must be 1.2 or 1.3")
+	}
+}
+
+// ServerPinHashes parses ServerPins ("sha256:" followed by a hex or base64
+// SHA-256 certificate fingerprint) into raw digests.
+func (c *Config) ServerPinHashes() ([][]byte, error) {
+	var hashes [][]byte
+	for _, pin := range c.ServerPins {
+		value, ok := strings.CutPrefix(pin, "sha256:")
+		if !ok {
+			return nil, errors.New("invalid server pin '" + pin + "' (expected sha256:<fingerprint>)")
+		}
+		hash, err := hex.DecodeString(strings.ReplaceAll(value, ":", ""))
+		if err != nil {
+			hash, err = base64.StdEncoding.DecodeString(value)
+		}
+		if err != nil || len(hash) != sha256.Size {
+			return nil, errors.New("invalid server pin '" + pin + "' (fingerprint must be a hex or base64 SHA-256 digest)")
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// ServerHeaderMap parses ServerHeaders into a map of canonical header name
+// (see http.CanonicalHeaderKey) to value. Names differing only in case are
+// the same header, so the last value for it wins.
+func (c *Config) ServerHeaderMap() (map[string]string, error) {
+	headers := make(map[string]string, len(c.ServerHeaders))
+	for _, entry := range c.ServerHeaders {
+		name, value, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, errors.New("invalid server header '" + entry + "' (expected Name: Value)")
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// LoadHeadersFile reads HeadersFile, if set, and merges its headers into
+// ServerHeaders. Blank lines and lines starting with # are skipped. Headers
+// already in ServerHeaders take precedence over the file.
+func (c *Config) LoadHeadersFile() error {
+	if c.HeadersFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.HeadersFile)
+	if err != nil {
+		return errors.New("failed to read headers file: " + err.Error())
+	}
+
+	var headers []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, _, ok := strings.Cut(line, ":"); !ok || strings.TrimSpace(name) == "" {
+			return errors.New("invalid header on line " + strconv.Itoa(i+1) + " of " + c.HeadersFile + " (expected Name: Value)")
+		}
+		headers = append(headers, line)
+	}
+	// ServerHeaderMap keeps the last value for a name
+	c.ServerHeaders = append(headers, c.ServerHeaders...)
+	return nil
+}
+
+// validateProxyAddr checks that addr is a SOCKS5 proxy URL.
+func validateProxyAddr(addr string) error {
+	if !strings.HasPrefix(addr, "socks5://") && !strings.HasPrefix(addr, "socks5h://") &&
+		!strings.HasPrefix(addr, "socks5s://") && !strings.HasPrefix(addr, "socks5hs://") {
+		return errors.New("proxy address must start with socks5://, socks5h://, socks5s:// or socks5hs://")
+	}
+
+	// Validate proxy URL format
+	proxyURL, err := url.Parse(addr)
+	if err != nil {
+		return errors.New("invalid proxy address format: " + err.Error())
+	}
+	if proxyURL.Hostname() == "" {
+		return errors.New("proxy address must include host")
+	}
+	if port := proxyURL.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return errors.New("proxy port must be between 1 and 65535")
+		}
+	}
+	return nil
+}
+
+// ProxyRule routes destinations whose host matches Pattern through the proxy
+// at ProxyAddr, or directly if ProxyAddr is empty.
+type ProxyRule struct {
+	Pattern   string
+	ProxyAddr string
+}
+
+// Keywords in proxy rules files.
+const (
+	// ProxyRuleDefault is the pattern of the rule for unmatched hosts.
+	ProxyRuleDefault = "default"
+	// ProxyRuleDirect is the target for connecting without a proxy.
+	ProxyRuleDirect = "direct"
+)
+
+// LoadProxyRules reads ProxyRulesFile, if set. Each line is
+// "pattern -> target", where pattern is a host, "*.domain" or "default", and
+// target is a SOCKS5 proxy URL or "direct"; blank lines and lines starting
+// with "#" are ignored. Rules are returned in file order, with the default
+// rule (pattern "*") last. Without a default rule, unmatched hosts use
+// ProxyAddr.
+func (c *Config) LoadProxyRules() ([]ProxyRule, error) {
+	if c.ProxyRulesFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.ProxyRulesFile)
+	if err != nil {
+		return nil, errors.New("failed to read proxy rules file: " + err.Error())
+	}
+
+	var rules []ProxyRule
+	var fallback *ProxyRule
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		where := "line " + strconv.Itoa(i+1) + " of " + c.ProxyRulesFile
+		pattern, target, ok := strings.Cut(line, "->")
+		pattern, target = strings.TrimSpace(pattern), strings.TrimSpace(target)
+		if !ok || pattern == "" || target == "" {
+			return nil, errors.New("invalid proxy rule on " + where + " (expected pattern -> proxy URL or direct)")
+		}
+
+		rule := ProxyRule{Pattern: pattern}
+		if !strings.EqualFold(target, ProxyRuleDirect) {
+			if err := validateProxyAddr(target); err != nil {
+				return nil, errors.New("invalid proxy rule on " + where + ": " + err.Error())
+			}
+			rule.ProxyAddr = target
+		}
+		if strings.EqualFold(pattern, ProxyRuleDefault) {
+			rule.Pattern = "*"
+			fallback = &rule
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if fallback != nil {
+		rules = append(rules, *fallback)
+	}
+	return rules, nil
+}
+
+// IsSensitiveHeader reports whether a header usually carries credentials.
+func IsSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	switch name {
+	case "authorization", "proxy-authorization", "cookie":
+		return true
+	}
+	for _, marker := range []string{"token", "secret", "api-key", "apikey", "auth"} {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientHeaders returns the audit headers identifying the local MCP client.
+// It returns an empty map when no client info is configured.
+func (c *Config) ClientHeaders() map[string]string {
+	headers := make(map[string]string)
+	if c.ClientPID > 0 {
+		headers["X-MCP-Client-PID"] = strconv.Itoa(c.ClientPID)
+	}
+	if c.ClientName != "" {
+		headers["X-MCP-Client-Name"] = c.ClientName
+	}
+	return headers
+}
+
+// DisabledCapabilities returns the client capabilities to strip from initialize.
+func (c *Config) DisabledCapabilities() []string {
+	var names []string
+	for _, name := range strings.Split(c.DisableCapabilities, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// LocallyHandledMethods returns the methods listed in HandleLocally.
+func (c *Config) LocallyHandledMethods() []string {
+	var methods []string
+	for _, method := range strings.Split(c.HandleLocally, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// DefaultProxyPort is the proxy port used when ProxyAddr has none, the
+// standard SOCKS port for every supported scheme.
+const DefaultProxyPort = "1080"
+
+// ProxyHost returns the proxy host:port from the ProxyAddr, with
+// DefaultProxyPort if it has no port.
 func (c *Config) ProxyHost() string {
 	u, err := url.Parse(c.ProxyAddr)
 	if err != nil {
 		return ""
 	}
+	if u.Port() == "" && u.Hostname() != "" {
+		return net.JoinHostPort(u.Hostname(), DefaultProxyPort)
+	}
 	return u.Host
 }
 
@@ -96,12 +804,17 @@ func (c *Config) ProxyAuth() (username, password string, ok bool) {
 	return u.User.Username(), password, hasPassword
 }
 
-// IsRemoteDNS returns true if the proxy should perform DNS resolution (socks5h://).
+// IsRemoteDNS returns true if the proxy should perform DNS resolution (socks5h:// or socks5hs://).
 func (c *Config) IsRemoteDNS() bool {
-	return strings.HasPrefix(c.ProxyAddr, "socks5h://")
+	return strings.HasPrefix(c.ProxyAddr, "socks5h://") || strings.HasPrefix(c.ProxyAddr, "socks5hs://")
+}
+
+// IsProxyTLS returns true if SOCKS5 is spoken over TLS to the proxy (socks5s:// or socks5hs://).
+func (c *Config) IsProxyTLS() bool {
+	return strings.HasPrefix(c.ProxyAddr, "socks5s://") || strings.HasPrefix(c.ProxyAddr, "socks5hs://")
 }
 
-// ProxyScheme returns the proxy scheme ("socks5" or "socks5h").
+// ProxyScheme returns the proxy scheme ("socks5", "socks5h", "socks5s" or "socks5hs").
 func (c *Config) ProxyScheme() string {
 	u, err := url.Parse(c.ProxyAddr)
 	if err != nil {
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// redacted replaces secrets in Dump output.
+const redacted = "[REDACTED]"
+
+// Dump returns the configuration as indented JSON for --print-config, with
+// credentials redacted. Every field is included, defaults and all; durations
+// are written like flag values ("30s"). The "resolved" object summarizes
+// what the bridge derives from the configuration, plus the entries in extra.
+func (c *Config) Dump(extra map[string]string) ([]byte, error) {
+	fields := make(map[string]any)
+	v := reflect.ValueOf(*c)
+	for i := 0; i < v.NumField(); i++ {
+		value := v.Field(i).Interface()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		fields[v.Type().Field(i).Name] = value
+	}
+
+	fields["ProxyAddr"] = RedactURL(c.ProxyAddr)
+	fields["ServerURL"] = RedactURL(c.ServerURL)
+	fields["OTLPEndpoint"] = RedactURL(c.OTLPEndpoint)
+	fields["EventWebhook"] = RedactURL(c.EventWebhook)
+	headers := make([]string, len(c.ServerHeaders))
+	for i, header := range c.ServerHeaders {
+		name, _, _ := strings.Cut(header, ":")
+		if IsSensitiveHeader(strings.TrimSpace(name)) {
+			header = name + ": " + redacted
+		}
+		headers[i] = header
+	}
+	fields["ServerHeaders"] = headers
+
+	dns := "local"
+	if c.IsRemoteDNS() {
+		dns = "remote"
+	}
+	resolved := map[string]any{
+		"proxy_scheme": c.ProxyScheme(),
+		"proxy_host":   c.ProxyHost(),
+		"proxy_tls":    c.IsProxyTLS(),
+		"dns":          dns,
+	}
+	if _, _, ok := c.ProxyAuth(); ok {
+		resolved["proxy_auth"] = "username/password"
+	}
+	for key, value := range extra {
+		resolved[key] = value
+	}
+
+	return json.MarshalIndent(map[string]any{
+		"config":   fields,
+		"resolved": resolved,
+	}, "", "  ")
+}
+
+// RedactURL strips credentials, query and fragment from a URL, so that it
+// can be logged or reported. A URL that does not parse is replaced by a
+// placeholder, since it may still hold a secret.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[invalid URL]"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,191 @@
+// Package echo provides a minimal MCP server that echoes requests back as
+// results, for testing the bridge end to end without a real MCP server.
+package echo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ServerName is reported in the echo server's initialize result.
+const ServerName = "mcp-over-socks-echo"
+
+// message is an incoming JSON-RPC message.
+type message struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// reply computes the response to msg, or nil if msg needs none.
+// initialize is answered with a minimal valid result so that real MCP
+// clients can complete the handshake; other requests get their method and
+// params back as the result.
+func reply(msg *message) []byte {
+	if len(msg.ID) == 0 || msg.Method == "" {
+		return nil
+	}
+
+	var result any
+	if msg.Method == "initialize" {
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		result = map[string]any{
+			"protocolVersion": params.ProtocolVersion,
+			"capabilities":    map[string]any{},
+			"serverInfo":      map[string]any{"name": ServerName, "version": "1.0.0"},
+		}
+	} else {
+		result = map[string]any{
+			"method": msg.Method,
+			"params": msg.Params,
+		}
+	}
+
+	data, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      msg.ID,
+		"result":  result,
+	})
+	return data
+}
+
+// readMessage decodes a JSON-RPC message from a request body.
+func readMessage(w http.ResponseWriter, r *http.Request) (*message, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, "invalid JSON-RPC message: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &msg, true
+}
+
+// NewStreamableHandler returns a Streamable HTTP echo server handler.
+// Each POSTed request is answered with a single JSON response.
+func NewStreamableHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		msg, ok := readMessage(w, r)
+		if !ok {
+			return
+		}
+		data := reply(msg)
+		if data == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
+
+// sseHandler is an SSE (2024-11-05) echo server.
+type sseHandler struct {
+	mu       sync.Mutex
+	sessions map[string]chan []byte
+}
+
+// NewSSEHandler returns an SSE echo server handler.
+// A GET opens an event stream whose endpoint event names the URL to POST
+// messages to; replies are sent on that stream.
+func NewSSEHandler() http.Handler {
+	return &sseHandler{sessions: make(map[string]chan []byte)}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *sseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.serveStream(w, r)
+	case http.MethodPost:
+		h.serveMessage(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *sseHandler) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	id := newSessionID()
+	events := make(chan []byte, 64)
+	h.mu.Lock()
+	h.sessions[id] = events
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.sessions, id)
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "event: endpoint\ndata: %s?sessionId=%s\n\n", r.URL.Path, id)
+	flusher.Flush()
+
+	for {
+		select {
+		case data := <-events:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (h *sseHandler) serveMessage(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	events, ok := h.sessions[r.URL.Query().Get("sessionId")]
+	h.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	msg, ok := readMessage(w, r)
+	if !ok {
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	if data := reply(msg); data != nil {
+		select {
+		case events <- data:
+		case <-r.Context().Done():
+		}
+	}
+}
+
+// newSessionID returns a random SSE session ID.
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -14,7 +14,9 @@ type LogLevel int
 const (
 	// LogLevelError logs only errors.
 	LogLevelError LogLevel = iota
-	// LogLevelInfo logs errors and informational messages.
+	// LogLevelWarn logs errors and warnings.
+	LogLevelWarn
+	// LogLevelInfo logs errors, warnings and informational messages.
 	LogLevelInfo
 	// LogLevelDebug logs everything including debug messages.
 	LogLevelDebug
@@ -25,6 +27,8 @@ func (l LogLevel) String() string {
 	switch l {
 	case LogLevelError:
 		return "ERROR"
+	case LogLevelWarn:
+		return "WARN"
 	case LogLevelInfo:
 		return "INFO"
 	case LogLevelDebug:
@@ -39,6 +43,8 @@ func ParseLogLevel(s string) LogLevel {
 	switch s {
 	case "error":
 		return LogLevelError
+	case "warn":
+		return LogLevelWarn
 	case "info":
 		return LogLevelInfo
 	case "debug":
@@ -48,6 +54,13 @@ func ParseLogLevel(s string) LogLevel {
 	}
 }
 
+// levelWriter is implemented by writers that handle severity themselves,
+// such as SyslogWriter. They receive the bare message without the timestamp
+// and level prefix.
+type levelWriter interface {
+	WriteLevel(level LogLevel, message string) error
+}
+
 // Logger is a simple logger that writes to stderr.
 type Logger struct {
 	level  LogLevel
@@ -81,8 +94,13 @@ func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 		return
 	}
 
-	timestamp := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
 	message := fmt.Sprintf(format, args...)
+	if lw, ok := l.writer.(levelWriter); ok {
+		lw.WriteLevel(level, message)
+		return
+	}
+
+	timestamp := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
 	fmt.Fprintf(l.writer, "[%s] %s: %s\n", timestamp, level.String(), message)
 }
 
@@ -91,6 +109,11 @@ func (l *Logger) Error(format string, args ...interface{}) {
 	l.log(LogLevelError, format, args...)
 }
 
+// Warn logs a warning message.
+func (l *Logger) Warn(format string, args ...interface{}) {
+	l.log(LogLevelWarn, format, args...)
+}
+
 // Info logs an informational message.
 func (l *Logger) Info(format string, args ...interface{}) {
 	l.log(LogLevelInfo, format, args...)
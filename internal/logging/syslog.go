@@ -0,0 +1,60 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// SyslogWriter writes log messages to syslog, mapping log levels to
+// syslog severities.
+type SyslogWriter struct {
+	w *syslog.Writer
+}
+
+// NewSyslogWriter connects to syslog.
+// addr is empty for the local syslog daemon, or "[network://]host:port" for a
+// remote one (the network defaults to udp).
+func NewSyslogWriter(addr, tag string) (*SyslogWriter, error) {
+	network := ""
+	if addr != "" {
+		network = "udp"
+		if scheme, rest, ok := strings.Cut(addr, "://"); ok {
+			network, addr = scheme, rest
+		}
+	}
+
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogWriter{w: w}, nil
+}
+
+// Write writes p to syslog at informational severity.
+func (s *SyslogWriter) Write(p []byte) (int, error) {
+	if err := s.w.Info(string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteLevel writes message to syslog at the severity matching level.
+func (s *SyslogWriter) WriteLevel(level LogLevel, message string) error {
+	switch level {
+	case LogLevelError:
+		return s.w.Err(message)
+	case LogLevelWarn:
+		return s.w.Warning(message)
+	case LogLevelDebug:
+		return s.w.Debug(message)
+	default:
+		return s.w.Info(message)
+	}
+}
+
+// Close closes the connection to syslog.
+func (s *SyslogWriter) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,28 @@
+//go:build windows || plan9
+
+package logging
+
+import "errors"
+
+// SyslogWriter is unavailable on this platform.
+type SyslogWriter struct{}
+
+// NewSyslogWriter always fails, since syslog is not supported on this platform.
+func NewSyslogWriter(addr, tag string) (*SyslogWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// Write implements io.Writer.
+func (s *SyslogWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("syslog is not supported on this platform")
+}
+
+// WriteLevel implements the level-aware writer interface.
+func (s *SyslogWriter) WriteLevel(level LogLevel, message string) error {
+	return errors.New("syslog is not supported on this platform")
+}
+
+// Close implements io.Closer.
+func (s *SyslogWriter) Close() error {
+	return nil
+}
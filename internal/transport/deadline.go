@@ -0,0 +1,29 @@
+package transport
+
+import (
+	"net"
+	"time"
+)
+
+// deadlineConn is a net.Conn that bounds every Read and Write by timeout,
+// so a socket stuck inside the tunnel turns into a prompt timeout error.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Read implements net.Conn.
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// Write implements net.Conn.
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
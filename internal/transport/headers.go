@@ -0,0 +1,37 @@
+package transport
+
+import "net/http"
+
+// HeaderTransport is an http.RoundTripper that adds fixed headers to every request.
+type HeaderTransport struct {
+	Base   http.RoundTripper
+	Header http.Header
+}
+
+// NewHeaderTransport wraps base so that every outbound request carries headers.
+// If base is nil, http.DefaultTransport is used.
+func NewHeaderTransport(base http.RoundTripper, headers map[string]string) *HeaderTransport {
+	h := make(http.Header, len(headers))
+	for name, value := range headers {
+		h.Set(name, value)
+	}
+	return &HeaderTransport{
+		Base:   base,
+		Header: h,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	for name, values := range t.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
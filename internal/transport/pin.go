@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+)
+
+// VerifyCertificatePins returns a tls.Config.VerifyConnection callback that
+// accepts a connection only if the SHA-256 fingerprint of the server's leaf
+// certificate matches one of pins. Several pins allow certificate rotation.
+// Unlike VerifyPeerCertificate, VerifyConnection also runs for resumed
+// sessions, so pinning cannot be bypassed through the session cache.
+func VerifyCertificatePins(pins [][]byte) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server presented no certificate to check against --server-pin")
+		}
+		fingerprint := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		for _, pin := range pins {
+			if bytes.Equal(pin, fingerprint[:]) {
+				return nil
+			}
+		}
+		return errors.New("server certificate fingerprint sha256:" + hex.EncodeToString(fingerprint[:]) +
+			" does not match any --server-pin")
+	}
+}
@@ -0,0 +1,115 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// ProxyRoute sends destinations whose host matches Pattern through Dialer,
+// or directly if Dialer is nil.
+//
+// A pattern is a host name ("api.example.com"), a wildcard matching any
+// subdomain ("*.example.com", which does not match "example.com" itself), or
+// "*" matching every host. Matching is case-insensitive.
+type ProxyRoute struct {
+	Pattern string
+	Dialer  *SOCKSDialer
+}
+
+// RuleBasedDialer picks the forwarding path for each destination from a
+// list of routes. The first route whose pattern matches the destination host
+// is used; destinations matching none go through the fallback dialer, or
+// directly if it is nil. Direct connections have dial settings of their own,
+// like those of a SOCKSDialer.
+type RuleBasedDialer struct {
+	routes   []ProxyRoute
+	fallback *SOCKSDialer
+	direct   net.Dialer
+
+	overrides map[string]string // Pinned "host:port" -> IP mappings for direct connections
+	ioTimeout time.Duration     // Per-operation socket read/write timeout, 0 to disable
+	dialSlots *DialLimiter      // Bounds in-flight direct dials, nil for no limit
+}
+
+// NewRuleBasedDialer creates a RuleBasedDialer trying routes in order.
+func NewRuleBasedDialer(routes []ProxyRoute, fallback *SOCKSDialer) *RuleBasedDialer {
+	return &RuleBasedDialer{routes: routes, fallback: fallback}
+}
+
+// SetResolveOverrides pins "host:port" destinations dialed directly to
+// specific IPs, as SOCKSDialer.SetResolveOverrides does for proxied ones.
+func (d *RuleBasedDialer) SetResolveOverrides(overrides map[string]string) {
+	d.overrides = overrides
+}
+
+// SetSocketIOTimeout bounds each read and write on direct connections, as
+// SOCKSDialer.SetSocketIOTimeout does for proxied ones.
+func (d *RuleBasedDialer) SetSocketIOTimeout(timeout time.Duration) {
+	d.ioTimeout = timeout
+}
+
+// SetDialLimiter bounds the direct dials in flight with l, which is usually
+// shared with the route dialers.
+func (d *RuleBasedDialer) SetDialLimiter(l *DialLimiter) {
+	d.dialSlots = l
+}
+
+// Route returns the dialer used for addr ("host:port" or a bare host), or
+// nil if addr is dialed directly.
+func (d *RuleBasedDialer) Route(addr string) *SOCKSDialer {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	for _, route := range d.routes {
+		if MatchHostPattern(route.Pattern, host) {
+			return route.Dialer
+		}
+	}
+	return d.fallback
+}
+
+// Dial connects to addr through the route selected for it.
+func (d *RuleBasedDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through the route selected for it.
+func (d *RuleBasedDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dialer := d.Route(addr); dialer != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	dialAddr := addr
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if ip, ok := d.overrides[net.JoinHostPort(host, port)]; ok {
+			dialAddr = net.JoinHostPort(ip, port)
+		}
+	}
+	release, err := d.dialSlots.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.direct.DialContext(ctx, network, dialAddr)
+	release()
+	if err != nil || d.ioTimeout <= 0 {
+		return conn, err
+	}
+	return &deadlineConn{Conn: conn, timeout: d.ioTimeout}, nil
+}
+
+// MatchHostPattern reports whether host matches a ProxyRoute pattern.
+func MatchHostPattern(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return strings.HasSuffix(host, pattern[1:])
+	default:
+		return host == pattern
+	}
+}
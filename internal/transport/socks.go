@@ -3,17 +3,43 @@ package transport
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 	"time"
 
+	"github.com/iiharu/mcp-over-socks/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/proxy"
 )
 
 // SOCKSDialer wraps a SOCKS5 proxy dialer.
 type SOCKSDialer struct {
 	dialer    proxy.Dialer
-	remoteDNS bool // If true, let the proxy resolve hostnames (socks5h://)
+	forward   forwardDialer // Connects to the proxy itself (plain TCP or TLS)
+	proxyAddr string
+	auth      *proxy.Auth
+	remoteDNS bool              // If true, let the proxy resolve hostnames (socks5h://)
+	overrides map[string]string // Pinned "host:port" -> IP mappings (like curl's --resolve)
+	tlsConfig *tls.Config       // TLS settings for https:// servers, nil for Go defaults
+
+	sessionCache tls.ClientSessionCache // TLS session cache for resumption, nil to disable
+	ioTimeout    time.Duration          // Per-operation socket read/write timeout, 0 to disable
+	decorate     RoundTripperDecorator  // Wraps the transport of HTTPClient, nil for none
+	dialSlots    *DialLimiter           // Bounds in-flight dials, nil for no limit
+	rules        *RuleBasedDialer       // Picks the proxy per destination for HTTPTransport, nil to always use this one
+	maxHeader    int64                  // Response header size limit for HTTPTransport, 0 for the net/http default
+}
+
+// RoundTripperDecorator wraps the SOCKS HTTP transport with middleware, such
+// as request signing or retries.
+type RoundTripperDecorator func(http.RoundTripper) http.RoundTripper
+
+// forwardDialer connects to the proxy itself.
+type forwardDialer interface {
+	proxy.Dialer
+	proxy.ContextDialer
 }
 
 // SOCKSError represents a SOCKS-related error with user-friendly message.
@@ -44,38 +70,170 @@ func NewSOCKSDialer(proxyAddr string, auth *proxy.Auth, remoteDNS bool) (*SOCKSD
 		}
 	}
 
-	dialer, err := proxy.SOCKS5("tcp", proxyAddr, auth, proxy.Direct)
-	if err != nil {
+	return newSOCKSDialer(proxyAddr, auth, remoteDNS, proxy.Direct)
+}
+
+// NewSOCKSDialerOverTLS creates a SOCKS5 dialer that speaks SOCKS5 over a TLS
+// connection to the proxy (socks5s:// and socks5hs://).
+// tlsConfig sets the trusted CAs and server name for the proxy; if its
+// ServerName is empty, the proxy host is used.
+func NewSOCKSDialerOverTLS(proxyAddr string, auth *proxy.Auth, remoteDNS bool, tlsConfig *tls.Config) (*SOCKSDialer, error) {
+	if proxyAddr == "" {
 		return nil, &SOCKSError{
-			Message: "Failed to create SOCKS5 dialer for " + proxyAddr,
-			Err:     err,
+			Message: "SOCKS proxy address is empty",
+		}
+	}
+
+	cfg := &tls.Config{}
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		if host, _, err := net.SplitHostPort(proxyAddr); err == nil {
+			cfg.ServerName = host
 		}
 	}
+	return newSOCKSDialer(proxyAddr, auth, remoteDNS, &tlsProxyDialer{config: cfg})
+}
+
+// NewSOCKSDialerFromDialer creates a SOCKSDialer around an existing proxy
+// dialer, such as one chained with proxy.FromURL. Dialers that do not
+// implement proxy.ContextDialer are supported; cancelled dials are then
+// abandoned and their connections closed once they complete.
+func NewSOCKSDialerFromDialer(dialer proxy.Dialer, remoteDNS bool) *SOCKSDialer {
 	return &SOCKSDialer{
 		dialer:    dialer,
+		forward:   proxy.Direct,
+		remoteDNS: remoteDNS,
+	}
+}
+
+// newSOCKSDialer creates a SOCKS5 dialer reaching the proxy through forward.
+func newSOCKSDialer(proxyAddr string, auth *proxy.Auth, remoteDNS bool, forward forwardDialer) (*SOCKSDialer, error) {
+	return &SOCKSDialer{
+		dialer:    &socks5Dialer{proxyAddr: proxyAddr, auth: auth, forward: forward},
+		forward:   forward,
+		proxyAddr: proxyAddr,
+		auth:      auth,
 		remoteDNS: remoteDNS,
 	}, nil
 }
 
+// SetResolveOverrides pins "host:port" addresses to fixed IP addresses,
+// bypassing DNS resolution for them.
+// Overrides apply in both DNS modes, since a pinned address must never be
+// re-resolved by the proxy either.
+func (d *SOCKSDialer) SetResolveOverrides(overrides map[string]string) {
+	d.overrides = overrides
+}
+
+// SetTLSConfig sets the TLS configuration used for https:// servers.
+func (d *SOCKSDialer) SetTLSConfig(cfg *tls.Config) {
+	d.tlsConfig = cfg
+}
+
+// SetTLSSessionCache enables TLS session resumption for https:// servers,
+// sharing cache across all transports created by this dialer.
+// A nil cache disables resumption.
+func (d *SOCKSDialer) SetTLSSessionCache(cache tls.ClientSessionCache) {
+	d.sessionCache = cache
+}
+
+// SetSocketIOTimeout bounds each read and write on connections returned by
+// DialContext, so that I/O stuck in the tunnel fails instead of hanging.
+// The timeout must exceed the longest expected idle period on a connection
+// (e.g. between SSE events), since an idle read also times out.
+// Zero disables it.
+func (d *SOCKSDialer) SetSocketIOTimeout(timeout time.Duration) {
+	d.ioTimeout = timeout
+}
+
+// DialLimiter bounds the number of dials in flight at once across the
+// dialers sharing it.
+type DialLimiter struct {
+	slots chan struct{}
+}
+
+// NewDialLimiter returns a limiter allowing n dials at once, or nil (no
+// limit) if n is not positive.
+func NewDialLimiter(n int) *DialLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &DialLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a free dial slot. The returned function releases it. A
+// nil limiter never waits.
+func (l *DialLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SetMaxConcurrentDials bounds the number of dials (including their SOCKS
+// handshakes) in flight at once; further dials wait for a slot, or until
+// their context is done. Zero removes the limit.
+func (d *SOCKSDialer) SetMaxConcurrentDials(n int) {
+	d.dialSlots = NewDialLimiter(n)
+}
+
+// SetDialLimiter bounds the dials in flight with l, which may be shared with
+// other dialers so that the bound covers all of them. A nil limiter removes
+// the limit.
+func (d *SOCKSDialer) SetDialLimiter(l *DialLimiter) {
+	d.dialSlots = l
+}
+
+// SetProxyRules makes HTTPTransport dial through rules, which pick the proxy
+// (or a direct connection) per destination. TLS and other HTTP settings of
+// this dialer still apply. A nil value restores dialing through this proxy.
+func (d *SOCKSDialer) SetProxyRules(rules *RuleBasedDialer) {
+	d.rules = rules
+}
+
+// SetMaxResponseHeaderBytes limits the size of response headers accepted by
+// HTTPTransport. Zero uses the net/http default.
+func (d *SOCKSDialer) SetMaxResponseHeaderBytes(n int64) {
+	d.maxHeader = n
+}
+
+// pinnedAddr returns the overridden address for addr, if one is configured.
+func (d *SOCKSDialer) pinnedAddr(addr string) (string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	ip, ok := d.overrides[net.JoinHostPort(host, port)]
+	if !ok {
+		return "", false
+	}
+	return net.JoinHostPort(ip, port), true
+}
+
 // Dial connects to the address on the named network through the SOCKS5 proxy.
 func (d *SOCKSDialer) Dial(network, addr string) (net.Conn, error) {
-	dialAddr := addr
-	if !d.remoteDNS {
-		// For socks5://, resolve the hostname locally first
-		resolved, err := d.resolveLocally(addr)
-		if err != nil {
-			return nil, err
-		}
-		dialAddr = resolved
-	}
-	// For socks5h://, pass the hostname as-is to let the proxy resolve it
-	return d.dialer.Dial(network, dialAddr)
+	return d.DialContext(context.Background(), network, addr)
 }
 
 // DialContext connects to the address on the named network through the SOCKS5 proxy with context.
-func (d *SOCKSDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+func (d *SOCKSDialer) DialContext(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "SOCKS dial", trace.WithAttributes(
+		attribute.String("socks.proxy", d.proxyAddr),
+		attribute.String("socks.target", addr),
+	))
+	defer func() { tracing.EndSpan(span, err) }()
+
 	dialAddr := addr
-	if !d.remoteDNS {
+	if pinned, ok := d.pinnedAddr(addr); ok {
+		dialAddr = pinned
+	} else if !d.remoteDNS {
 		// For socks5://, resolve the hostname locally first
 		resolved, err := d.resolveLocallyWithContext(ctx, addr)
 		if err != nil {
@@ -85,8 +243,24 @@ func (d *SOCKSDialer) DialContext(ctx context.Context, network, addr string) (ne
 	}
 	// For socks5h://, pass the hostname as-is to let the proxy resolve it
 
+	release, err := d.dialSlots.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err = d.dialContext(ctx, network, dialAddr, release)
+	if err != nil || d.ioTimeout <= 0 {
+		return conn, err
+	}
+	return &deadlineConn{Conn: conn, timeout: d.ioTimeout}, nil
+}
+
+// dialContext dials addr through the proxy, honoring ctx even if the
+// underlying dialer does not support contexts. It calls release once the
+// dial has finished, which for an abandoned dial may be after it returns.
+func (d *SOCKSDialer) dialContext(ctx context.Context, network, dialAddr string, release func()) (net.Conn, error) {
 	// Check if the dialer supports DialContext
 	if ctxDialer, ok := d.dialer.(proxy.ContextDialer); ok {
+		defer release()
 		return ctxDialer.DialContext(ctx, network, dialAddr)
 	}
 
@@ -99,48 +273,26 @@ func (d *SOCKSDialer) DialContext(ctx context.Context, network, addr string) (ne
 
 	go func() {
 		conn, err := d.dialer.Dial(network, dialAddr)
+		release()
 		resultCh <- dialResult{conn: conn, err: err}
 	}()
 
 	select {
 	case <-ctx.Done():
+		// Close the connection if the dial completes after all
+		go func() {
+			if result := <-resultCh; result.conn != nil {
+				result.conn.Close()
+			}
+		}()
 		return nil, ctx.Err()
 	case result := <-resultCh:
 		return result.conn, result.err
 	}
 }
 
-// resolveLocally resolves the hostname part of addr to an IP address.
+// resolveLocallyWithContext resolves the hostname part of addr to an IP address.
 // Returns the addr with hostname replaced by IP, or original addr if it's already an IP.
-func (d *SOCKSDialer) resolveLocally(addr string) (string, error) {
-	host, port, err := net.SplitHostPort(addr)
-	if err != nil {
-		return addr, nil // Return as-is if parsing fails
-	}
-
-	// Check if it's already an IP address
-	if ip := net.ParseIP(host); ip != nil {
-		return addr, nil // Already an IP, no resolution needed
-	}
-
-	// Resolve the hostname
-	ips, err := net.LookupHost(host)
-	if err != nil {
-		return "", &SOCKSError{
-			Message: "Failed to resolve hostname '" + host + "' locally",
-			Err:     err,
-		}
-	}
-	if len(ips) == 0 {
-		return "", &SOCKSError{
-			Message: "No IP addresses found for hostname '" + host + "'",
-		}
-	}
-
-	return net.JoinHostPort(ips[0], port), nil
-}
-
-// resolveLocallyWithContext is like resolveLocally but with context support.
 func (d *SOCKSDialer) resolveLocallyWithContext(ctx context.Context, addr string) (string, error) {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -177,15 +329,41 @@ func (d *SOCKSDialer) IsRemoteDNS() bool {
 
 // HTTPTransport creates an http.Transport that uses this SOCKS5 dialer.
 func (d *SOCKSDialer) HTTPTransport() *http.Transport {
-	return &http.Transport{
-		DialContext: d.DialContext,
+	t := &http.Transport{
+		DialContext:            d.DialContext,
+		MaxResponseHeaderBytes: d.maxHeader,
+	}
+	if d.rules != nil {
+		t.DialContext = d.rules.DialContext
 	}
+	if d.tlsConfig != nil || d.sessionCache != nil {
+		cfg := &tls.Config{}
+		if d.tlsConfig != nil {
+			cfg = d.tlsConfig.Clone()
+		}
+		if cfg.ClientSessionCache == nil {
+			cfg.ClientSessionCache = d.sessionCache
+		}
+		t.TLSClientConfig = cfg
+	}
+	return t
 }
 
-// HTTPClient creates an http.Client that uses this SOCKS5 dialer.
+// SetRoundTripperDecorator sets middleware that HTTPClient layers on top of
+// HTTPTransport. A nil decorator uses the plain SOCKS transport.
+func (d *SOCKSDialer) SetRoundTripperDecorator(decorate RoundTripperDecorator) {
+	d.decorate = decorate
+}
+
+// HTTPClient creates an http.Client that uses this SOCKS5 dialer, wrapped by
+// the RoundTripper decorator if one is set.
 func (d *SOCKSDialer) HTTPClient(timeout time.Duration) *http.Client {
+	var rt http.RoundTripper = d.HTTPTransport()
+	if d.decorate != nil {
+		rt = d.decorate(rt)
+	}
 	return &http.Client{
-		Transport: d.HTTPTransport(),
+		Transport: rt,
 		Timeout:   timeout,
 	}
 }
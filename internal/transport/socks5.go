@@ -0,0 +1,422 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929).
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+
+	socks5CmdConnect = 0x01
+	socks5CmdBind    = 0x02
+
+	socks5ReplyNotAllowed = 0x02
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+// ErrProxyAuthRejected is returned when the proxy rejects the configured
+// username and password.
+var ErrProxyAuthRejected = errors.New("proxy rejected credentials")
+
+// ErrProxyAuthRequired is returned when the proxy requires authentication
+// but no credentials are configured.
+var ErrProxyAuthRequired = errors.New("proxy requires authentication, but no credentials are configured")
+
+// ErrProxyPolicy is returned when the proxy's ruleset does not allow the
+// requested connection (SOCKS5 reply 0x02).
+var ErrProxyPolicy = errors.New("connection not allowed by ruleset")
+
+// socks5ReplyMessages describes the SOCKS5 reply codes.
+var socks5ReplyMessages = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socks5Handshake performs method negotiation and, if requested by the proxy,
+// username/password authentication on conn.
+// With credentials, username/password is offered first so that proxies
+// honoring the client's preference authenticate, with no-auth as a fallback
+// for proxies that do not support it.
+func socks5Handshake(conn net.Conn, auth *proxy.Auth) error {
+	greeting := []byte{socks5Version, 1, socks5AuthNone}
+	if auth != nil {
+		greeting = []byte{socks5Version, 2, socks5AuthPassword, socks5AuthNone}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return errors.New("unexpected protocol version " + strconv.Itoa(int(reply[0])))
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+		return nil
+	case socks5AuthPassword:
+		if auth == nil {
+			return ErrProxyAuthRequired
+		}
+		return socks5Authenticate(conn, auth)
+	case socks5AuthNoAccept:
+		if auth == nil {
+			// Only no-auth was offered
+			return ErrProxyAuthRequired
+		}
+		return errors.New("no acceptable authentication methods")
+	default:
+		return errors.New("unsupported authentication method " + strconv.Itoa(int(reply[1])))
+	}
+}
+
+// socks5Authenticate performs the username/password sub-negotiation (RFC 1929).
+func socks5Authenticate(conn net.Conn, auth *proxy.Auth) error {
+	if len(auth.User) > 255 || len(auth.Password) > 255 {
+		return errors.New("username or password too long")
+	}
+	req := []byte{0x01, byte(len(auth.User))}
+	req = append(req, auth.User...)
+	req = append(req, byte(len(auth.Password)))
+	req = append(req, auth.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return ErrProxyAuthRejected
+	}
+	return nil
+}
+
+// socks5Request sends a command for addr ("host:port") on conn.
+func socks5Request(conn net.Conn, cmd byte, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return errors.New("invalid port " + portStr)
+	}
+
+	req := []byte{socks5Version, cmd, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("hostname too long")
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+
+	_, err = conn.Write(req)
+	return err
+}
+
+// socks5ReadReply reads a reply from conn and returns its bound address.
+func socks5ReadReply(conn net.Conn) (*socks5Addr, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != socks5Version {
+		return nil, errors.New("unexpected protocol version " + strconv.Itoa(int(header[0])))
+	}
+	if header[1] == socks5ReplyNotAllowed {
+		return nil, ErrProxyPolicy
+	}
+	if header[1] != 0x00 {
+		msg, ok := socks5ReplyMessages[header[1]]
+		if !ok {
+			msg = "unknown error " + strconv.Itoa(int(header[1]))
+		}
+		return nil, errors.New(msg)
+	}
+
+	addr := &socks5Addr{}
+	switch header[3] {
+	case socks5AddrIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return nil, err
+		}
+		addr.IP = ip
+	case socks5AddrIPv6:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return nil, err
+		}
+		addr.IP = ip
+	case socks5AddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return nil, err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return nil, err
+		}
+		addr.Name = string(name)
+	default:
+		return nil, errors.New("unknown address type " + strconv.Itoa(int(header[3])))
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, err
+	}
+	addr.Port = int(binary.BigEndian.Uint16(port))
+	return addr, nil
+}
+
+// socks5Dialer connects through a SOCKS5 proxy with the CONNECT command.
+// Unlike proxy.SOCKS5, it reports authentication failures distinctly and
+// aborts the handshake when the context is done.
+type socks5Dialer struct {
+	proxyAddr string
+	auth      *proxy.Auth
+	forward   forwardDialer
+}
+
+// Dial implements proxy.Dialer.
+func (d *socks5Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer.
+func (d *socks5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, &SOCKSError{Message: "SOCKS5 does not support network " + network}
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, proxyConnectError(d.proxyAddr, err)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	err = socks5Connect(conn, d.auth, addr)
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, ErrProxyPolicy) {
+		conn.Close()
+		host, _, _ := net.SplitHostPort(addr)
+		return nil, &SOCKSError{
+			Message: "Proxy policy forbids connecting to " + host + " (check the proxy's allowlist)",
+			Err:     err,
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &SOCKSError{
+			Message: "SOCKS5 CONNECT to " + addr + " via " + d.proxyAddr + " failed",
+			Err:     err,
+		}
+	}
+	return conn, nil
+}
+
+// socks5Connect runs the handshake and CONNECT request on conn.
+func socks5Connect(conn net.Conn, auth *proxy.Auth, addr string) error {
+	if err := socks5Handshake(conn, auth); err != nil {
+		return err
+	}
+	if err := socks5Request(conn, socks5CmdConnect, addr); err != nil {
+		return err
+	}
+	_, err := socks5ReadReply(conn)
+	return err
+}
+
+// socks5Addr is an address reported by a SOCKS5 proxy.
+type socks5Addr struct {
+	Name string // Hostname, if the proxy reported one
+	IP   net.IP
+	Port int
+}
+
+// Network implements net.Addr.
+func (a *socks5Addr) Network() string { return "tcp" }
+
+// String implements net.Addr.
+func (a *socks5Addr) String() string {
+	host := a.Name
+	if a.IP != nil {
+		host = a.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.Port))
+}
+
+// SOCKSBind is a pending SOCKS5 BIND: the proxy is listening on BoundAddr
+// for a single inbound connection.
+type SOCKSBind struct {
+	conn      net.Conn
+	boundAddr net.Addr
+}
+
+// BoundAddr returns the address the proxy is listening on. It must be passed
+// to the remote peer so that it can connect back.
+func (b *SOCKSBind) BoundAddr() net.Addr {
+	return b.boundAddr
+}
+
+// Accept waits for the proxy to report the inbound connection and returns it.
+// It may be called only once.
+func (b *SOCKSBind) Accept(ctx context.Context) (net.Conn, error) {
+	stop := context.AfterFunc(ctx, func() {
+		b.conn.SetDeadline(time.Now())
+	})
+	peer, err := socks5ReadReply(b.conn)
+	if !stop() {
+		b.conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		b.conn.Close()
+		return nil, &SOCKSError{
+			Message: "SOCKS5 BIND failed to accept a connection",
+			Err:     err,
+		}
+	}
+	return &socks5Conn{Conn: b.conn, remoteAddr: peer}, nil
+}
+
+// Close abandons the BIND.
+func (b *SOCKSBind) Close() error {
+	return b.conn.Close()
+}
+
+// socks5Conn is a proxied connection reporting the peer address given by the proxy.
+type socks5Conn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+// RemoteAddr implements net.Conn.
+func (c *socks5Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// Bind issues the SOCKS5 BIND command, asking the proxy to listen for an
+// inbound connection from addr ("host:port", the expected peer).
+// It returns once the proxy is listening; call Accept on the result to wait
+// for the connection.
+func (d *SOCKSDialer) Bind(ctx context.Context, addr string) (*SOCKSBind, error) {
+	if d.proxyAddr == "" {
+		return nil, &SOCKSError{Message: "SOCKS5 BIND requires a dialer created with the proxy address"}
+	}
+
+	bindAddr := addr
+	if pinned, ok := d.pinnedAddr(addr); ok {
+		bindAddr = pinned
+	} else if !d.remoteDNS {
+		resolved, err := d.resolveLocallyWithContext(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		bindAddr = resolved
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, proxyConnectError(d.proxyAddr, err)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	bound, err := d.negotiateBind(conn, bindAddr)
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &SOCKSError{
+			Message: "SOCKS5 BIND for " + addr + " failed",
+			Err:     err,
+		}
+	}
+
+	// A proxy listening on all interfaces reports the unspecified address;
+	// the peer must connect to the proxy host instead.
+	if bound.IP != nil && bound.IP.IsUnspecified() {
+		if host, _, err := net.SplitHostPort(d.proxyAddr); err == nil {
+			bound = &socks5Addr{Port: bound.Port}
+			if ip := net.ParseIP(host); ip != nil {
+				bound.IP = ip
+			} else {
+				bound.Name = host
+			}
+		}
+	}
+	return &SOCKSBind{conn: conn, boundAddr: bound}, nil
+}
+
+// negotiateBind runs the handshake and BIND request, returning the bound address.
+func (d *SOCKSDialer) negotiateBind(conn net.Conn, addr string) (*socks5Addr, error) {
+	if err := socks5Handshake(conn, d.auth); err != nil {
+		return nil, err
+	}
+	if err := socks5Request(conn, socks5CmdBind, addr); err != nil {
+		return nil, err
+	}
+	return socks5ReadReply(conn)
+}
+
+// proxyConnectError describes a failure of forward to reach the proxy at
+// proxyAddr. Errors that already describe the failure, such as a failed TLS
+// handshake with the proxy, are returned as is.
+func proxyConnectError(proxyAddr string, err error) error {
+	var socksErr *SOCKSError
+	if errors.As(err, &socksErr) {
+		return err
+	}
+	return &SOCKSError{
+		Message: "Failed to connect to SOCKS proxy " + proxyAddr,
+		Err:     err,
+	}
+}
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tlsProxyDialer connects to a SOCKS proxy over TLS, for proxies that wrap
+// the SOCKS protocol in TLS (stunnel-style, socks5s://).
+type tlsProxyDialer struct {
+	config *tls.Config
+}
+
+// Dial implements proxy.Dialer.
+func (d *tlsProxyDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer. A failure to connect is
+// returned as is, while a failed TLS handshake is a *SOCKSError, so that the
+// two can be told apart.
+func (d *tlsProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	raw, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, d.config)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, &SOCKSError{
+			Message: "TLS handshake with SOCKS proxy " + addr + " failed",
+			Err:     err,
+		}
+	}
+	return conn, nil
+}
+
+// LoadCertPool returns a cert pool with the PEM certificates in path.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// LoadCertPoolDir returns a cert pool with the PEM certificates in every
+// *.pem and *.crt file in dir. Other files are ignored.
+func LoadCertPoolDir(dir string) (*x509.CertPool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA directory: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	loaded := 0
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", path)
+		}
+		loaded++
+	}
+	if loaded == 0 {
+		return nil, fmt.Errorf("no *.pem or *.crt CA files found in %s", dir)
+	}
+	return pool, nil
+}
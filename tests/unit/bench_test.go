@@ -0,0 +1,107 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/echo"
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// signalWriter reports a copy of every Write on ch.
+type signalWriter struct {
+	ch chan []byte
+}
+
+func (w *signalWriter) Write(p []byte) (int, error) {
+	w.ch <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+// startLocalEchoBridge runs a bridge that answers "echo" locally with its
+// params, so messages go between stdin and stdout without HTTP round trips.
+func startLocalEchoBridge(tb testing.TB, stdout io.Writer) *io.PipeWriter {
+	tb.Helper()
+	srv := httptest.NewServer(echo.NewStreamableHandler())
+	tb.Cleanup(srv.Close)
+
+	stdinR, stdinW := io.Pipe()
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+	b := bridge.NewWithIO(newTestConfig(srv.URL+"/mcp"), &http.Client{}, logger, bridge.TransportStreamable, stdinR, stdout)
+	b.HandleLocally("echo", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return params, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go b.Run(ctx)
+	tb.Cleanup(func() {
+		cancel()
+		stdinW.Close()
+	})
+	return stdinW
+}
+
+func BenchmarkBridgeSmallMessage(b *testing.B) {
+	stdout := &signalWriter{ch: make(chan []byte, 1)}
+	stdin := startLocalEchoBridge(b, stdout)
+	line := []byte(`{"jsonrpc":"2.0","id":1,"method":"echo","params":{"x":1}}` + "\n")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stdin.Write(line)
+		<-stdout.ch
+	}
+}
+
+func TestBridgeKeepsMessagesIntactAcrossSizes(t *testing.T) {
+	stdout := &signalWriter{ch: make(chan []byte, 64)}
+	stdin := startLocalEchoBridge(t, stdout)
+
+	// Mix small frames with ones well over the scanner's initial buffer
+	const n = 200
+	want := make(map[int]string, n)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			size := []int{1, 100, 5000, 70 * 1024}[i%4]
+			want[i] = strings.Repeat(string(rune('a'+i%26)), size)
+			fmt.Fprintf(stdin, `{"jsonrpc":"2.0","id":%d,"method":"echo","params":{"s":%q}}`+"\n", i, want[i])
+		}
+	}()
+
+	got := make(map[int]string, n)
+	for len(got) < n {
+		frame := <-stdout.ch
+		var resp struct {
+			ID     int `json:"id"`
+			Result struct {
+				S string `json:"s"`
+			} `json:"result"`
+		}
+		if !strings.HasSuffix(string(frame), "\n") || strings.Count(string(frame), "\n") != 1 {
+			t.Fatalf("frame is not exactly one line: %.80q", frame)
+		}
+		if err := json.Unmarshal(frame, &resp); err != nil {
+			t.Fatalf("corrupt frame %.80q: %v", frame, err)
+		}
+		got[resp.ID] = resp.Result.S
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if got[i] != want[i] {
+			t.Errorf("response %d has %d bytes, want %d", i, len(got[i]), len(want[i]))
+		}
+	}
+}
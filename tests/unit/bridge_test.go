@@ -0,0 +1,1927 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/config"
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+	"github.com/iiharu/mcp-over-socks/internal/transport"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Lines returns the complete lines written so far.
+func (b *syncBuffer) Lines() []string {
+	s := b.String()
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return strings.Split(s[:i], "\n")
+	}
+	return nil
+}
+
+// rpcError makes mockMCPServer answer with a JSON-RPC error.
+type rpcError struct {
+	Code    int
+	Message string
+}
+
+// httpStatus makes mockMCPServer fail the HTTP request with a status code.
+type httpStatus int
+
+// rawReply makes mockMCPServer send these fields, plus the request id, as the
+// whole reply, without adding "jsonrpc".
+type rawReply map[string]any
+
+// mockMCPServer is a minimal Streamable HTTP MCP server.
+// Requests are answered with Respond (echoing params by default), which may
+// return an rpcError or httpStatus to fail the request, or a rawReply;
+// notifications and responses are accepted with 202.
+type mockMCPServer struct {
+	*httptest.Server
+
+	// Respond computes the result for a request.
+	Respond func(method string, params json.RawMessage) any
+
+	mu       sync.Mutex
+	messages []map[string]any
+	headers  []http.Header
+}
+
+// startMockMCPServer starts a mock Streamable HTTP MCP server.
+func startMockMCPServer(t *testing.T) *mockMCPServer {
+	t.Helper()
+	m := &mockMCPServer{
+		Respond: func(method string, params json.RawMessage) any {
+			return params
+		},
+	}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockMCPServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var msg struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var decoded map[string]any
+	json.Unmarshal(body, &decoded)
+	m.mu.Lock()
+	m.messages = append(m.messages, decoded)
+	m.headers = append(m.headers, r.Header.Clone())
+	respond := m.Respond
+	m.mu.Unlock()
+
+	if len(msg.ID) == 0 || msg.Method == "" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	reply := map[string]any{"jsonrpc": "2.0", "id": msg.ID}
+	switch result := respond(msg.Method, msg.Params).(type) {
+	case httpStatus:
+		w.WriteHeader(int(result))
+		return
+	case rawReply:
+		reply = map[string]any{"id": msg.ID}
+		for k, v := range result {
+			reply[k] = v
+		}
+	case rpcError:
+		reply["error"] = map[string]any{"code": result.Code, "message": result.Message}
+	default:
+		reply["result"] = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// Messages returns the decoded messages received so far.
+func (m *mockMCPServer) Messages() []map[string]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]map[string]any(nil), m.messages...)
+}
+
+// Headers returns the request headers received so far.
+func (m *mockMCPServer) Headers() []http.Header {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]http.Header(nil), m.headers...)
+}
+
+// testBridge is a bridge running against piped stdio.
+type testBridge struct {
+	*bridge.Bridge
+
+	stdin  *io.PipeWriter
+	stdout *syncBuffer
+	logs   *syncBuffer
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// newTestConfig returns a valid config pointing at serverURL.
+func newTestConfig(serverURL string) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.ProxyAddr = "socks5://127.0.0.1:1080"
+	cfg.ServerURL = serverURL
+	return cfg
+}
+
+// startTestBridge runs a Streamable HTTP bridge for cfg in the background.
+func startTestBridge(t *testing.T, cfg *config.Config) *testBridge {
+	t.Helper()
+	return startTestBridgeWithClient(t, cfg, &http.Client{Timeout: cfg.Timeout}, bridge.TransportStreamable)
+}
+
+// startTestBridgeWithClient runs a bridge with a custom HTTP client and transport.
+func startTestBridgeWithClient(t *testing.T, cfg *config.Config, client *http.Client, tType bridge.TransportType) *testBridge {
+	t.Helper()
+	tb := newTestBridgeWithClient(t, cfg, client, tType)
+	tb.start()
+	return tb
+}
+
+// newTestBridge creates a Streamable HTTP bridge for cfg without running it,
+// so that it can be configured first; start runs it.
+func newTestBridge(t *testing.T, cfg *config.Config) *testBridge {
+	t.Helper()
+	return newTestBridgeWithClient(t, cfg, &http.Client{Timeout: cfg.Timeout}, bridge.TransportStreamable)
+}
+
+// newTestBridgeWithClient creates a bridge with a custom HTTP client and
+// transport without running it.
+func newTestBridgeWithClient(t *testing.T, cfg *config.Config, client *http.Client, tType bridge.TransportType) *testBridge {
+	t.Helper()
+	return newTestBridgeWithStdout(t, cfg, client, tType, nil)
+}
+
+// newTestBridgeWithStdout is newTestBridgeWithClient with the bridge's
+// stdout replaced by wrap(tb.stdout), for tests that interfere with writes.
+func newTestBridgeWithStdout(t *testing.T, cfg *config.Config, client *http.Client, tType bridge.TransportType, wrap func(io.Writer) io.Writer) *testBridge {
+	t.Helper()
+	stdinR, stdinW := io.Pipe()
+	tb := &testBridge{
+		stdin:  stdinW,
+		stdout: &syncBuffer{},
+		logs:   &syncBuffer{},
+		done:   make(chan error, 1),
+	}
+	var stdout io.Writer = tb.stdout
+	if wrap != nil {
+		stdout = wrap(tb.stdout)
+	}
+	logger := logging.NewWithWriter(logging.LogLevelDebug, tb.logs)
+	tb.Bridge = bridge.NewWithIO(cfg, client, logger, tType, stdinR, stdout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tb.ctx, tb.cancel = ctx, cancel
+	t.Cleanup(func() {
+		cancel()
+		stdinW.Close()
+	})
+	return tb
+}
+
+// start runs the bridge in the background.
+func (tb *testBridge) start() {
+	go func() {
+		tb.done <- tb.Run(tb.ctx)
+	}()
+}
+
+// send writes one line to the bridge's stdin.
+func (tb *testBridge) send(t *testing.T, line string) {
+	t.Helper()
+	if _, err := io.WriteString(tb.stdin, line+"\n"); err != nil {
+		t.Fatalf("failed to write stdin: %v", err)
+	}
+}
+
+// waitLines waits until stdout has at least n lines.
+func (tb *testBridge) waitLines(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if lines := tb.stdout.Lines(); len(lines) >= n {
+			return lines
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d stdout lines, got: %q", n, tb.stdout.String())
+	return nil
+}
+
+// stop cancels the bridge and returns the error from Run.
+func (tb *testBridge) stop(t *testing.T) error {
+	t.Helper()
+	tb.cancel()
+	select {
+	case err := <-tb.done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("bridge did not stop")
+		return nil
+	}
+}
+
+func TestBridgeForwardsRequest(t *testing.T) {
+	srv := startMockMCPServer(t)
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{"cursor":"a"}}`)
+	lines := tb.waitLines(t, 1)
+
+	var resp struct {
+		ID     int            `json:"id"`
+		Result map[string]any `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", lines[0], err)
+	}
+	if resp.ID != 1 || resp.Result["cursor"] != "a" {
+		t.Errorf("unexpected response: %s", lines[0])
+	}
+
+	if err := tb.stop(t); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestBridgeProtocolVersionHeader(t *testing.T) {
+	srv := startMockMCPServer(t)
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.ProtocolVersion = "2025-03-26"
+	tb := startTestBridge(t, cfg)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	tb.waitLines(t, 1)
+	tb.stop(t)
+
+	headers := srv.Headers()
+	if len(headers) == 0 {
+		t.Fatal("server received no requests")
+	}
+	if got := headers[0].Get("MCP-Protocol-Version"); got != "2025-03-26" {
+		t.Errorf("MCP-Protocol-Version = %q, want %q", got, "2025-03-26")
+	}
+}
+
+func TestBridgeWarnsOnLargeMessage(t *testing.T) {
+	srv := startMockMCPServer(t)
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.WarnMessageBytes = 1024
+	tb := startTestBridge(t, cfg)
+
+	small := `{"jsonrpc":"2.0","id":1,"method":"ping"}`
+	large := `{"jsonrpc":"2.0","id":2,"method":"ping","params":{"blob":"` + strings.Repeat("x", 2048) + `"}}`
+	tb.send(t, small)
+	tb.waitLines(t, 1)
+	if strings.Contains(tb.logs.String(), "Large message") {
+		t.Errorf("unexpected warning for small message: %s", tb.logs.String())
+	}
+
+	tb.send(t, large)
+	tb.waitLines(t, 2)
+	if !strings.Contains(tb.logs.String(), "WARN: Large message from stdin") {
+		t.Errorf("expected large message warning, logs: %s", tb.logs.String())
+	}
+	if got := len(srv.Messages()); got != 2 {
+		t.Errorf("server received %d messages, want 2 (large message still forwarded)", got)
+	}
+}
+
+func TestBridgeFailsPendingOnServerClose(t *testing.T) {
+	srv := startMockSSEServer(t)
+	srv.OnMessage = func(msg map[string]any) any { return nil } // never answer
+	tb := startSSETestBridge(t, newTestConfig(srv.URL+"/sse"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"slow"}}`)
+	srv.waitMessages(t, 1)
+	srv.CloseStream()
+
+	lines := tb.waitLines(t, 1)
+	var resp struct {
+		ID    int `json:"id"`
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", lines[0], err)
+	}
+	if resp.ID != 7 {
+		t.Errorf("error response id = %d, want 7", resp.ID)
+	}
+	if resp.Error.Code != bridge.CodeConnectionClosed {
+		t.Errorf("error code = %d, want %d", resp.Error.Code, bridge.CodeConnectionClosed)
+	}
+}
+
+func TestBridgeErrorLog(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		switch method {
+		case "tools/call":
+			return rpcError{Code: -32602, Message: "unknown tool"}
+		case "resources/read":
+			return httpStatus(http.StatusServiceUnavailable)
+		}
+		return params
+	}
+	errLog := &syncBuffer{}
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+	tb.SetErrorLog(errLog)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"nope"}}`)
+	tb.waitLines(t, 1)
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"resources/read","params":{"uri":"file:///x"}}`)
+	tb.waitLines(t, 2)
+	tb.stop(t)
+
+	lines := errLog.Lines()
+	if len(lines) != 2 {
+		t.Fatalf("error log has %d entries, want 2: %q", len(lines), errLog.String())
+	}
+
+	var entries []map[string]any
+	for _, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid error log entry %q: %v", line, err)
+		}
+		if entry["time"] == "" {
+			t.Errorf("entry missing timestamp: %q", line)
+		}
+		entries = append(entries, entry)
+	}
+
+	if entries[0]["origin"] != "server" || entries[0]["method"] != "tools/call" || entries[0]["code"] != float64(-32602) {
+		t.Errorf("unexpected server error entry: %v", entries[0])
+	}
+	if entries[1]["origin"] != "bridge" || entries[1]["method"] != "resources/read" || entries[1]["id"] != float64(2) {
+		t.Errorf("unexpected bridge error entry: %v", entries[1])
+	}
+}
+
+func TestBridgeSurvivesFailingPOSTEndpoint(t *testing.T) {
+	srv := startMockSSEServer(t)
+	srv.OnMessage = func(msg map[string]any) any {
+		return httpStatus(http.StatusServiceUnavailable)
+	}
+	tb := startSSETestBridge(t, newTestConfig(srv.URL+"/sse"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	lines := tb.waitLines(t, 1)
+	if !strings.Contains(lines[0], `"id":1`) || !strings.Contains(lines[0], `"error"`) {
+		t.Fatalf("expected error response for failed POST, got %s", lines[0])
+	}
+
+	// The SSE stream keeps delivering server-initiated messages
+	srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/tools/list_changed"})
+	lines = tb.waitLines(t, 2)
+	if !strings.Contains(lines[1], "notifications/tools/list_changed") {
+		t.Errorf("expected server notification, got %s", lines[1])
+	}
+
+	// And later requests are still attempted
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	lines = tb.waitLines(t, 3)
+	if !strings.Contains(lines[2], `"id":2`) {
+		t.Errorf("expected error response for second request, got %s", lines[2])
+	}
+}
+
+func TestBridgeClassifiesWriteTimeout(t *testing.T) {
+	srv := startMockMCPServer(t)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	srv.Respond = func(method string, params json.RawMessage) any {
+		<-release
+		return params
+	}
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.Timeout = 100 * time.Millisecond
+	tb := startTestBridge(t, cfg)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+	lines := tb.waitLines(t, 1)
+	if !strings.Contains(lines[0], "request timeout") {
+		t.Errorf("expected timeout error response, got %s", lines[0])
+	}
+	if !strings.Contains(tb.logs.String(), "Request timed out. Please check:") {
+		t.Errorf("expected timeout checklist in logs, got: %s", tb.logs.String())
+	}
+}
+
+func TestBridgeMethodTimeoutOverride(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		time.Sleep(300 * time.Millisecond)
+		return params
+	}
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.Timeout = 100 * time.Millisecond
+	cfg.MethodTimeouts = []string{"tools/*=1s", "tools/call=5s"}
+	tb := startTestBridge(t, cfg)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"ok":true}}`)
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"resources/list","params":{"ok":true}}`)
+	lines := tb.waitLines(t, 2)
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, `"id":1`):
+			if !strings.Contains(line, `"result":{"ok":true}`) {
+				t.Errorf("expected tools/call to succeed under its override, got %s", line)
+			}
+		case strings.Contains(line, `"id":2`):
+			if !strings.Contains(line, "request timeout") {
+				t.Errorf("expected resources/list to time out, got %s", line)
+			}
+		default:
+			t.Errorf("unexpected line %s", line)
+		}
+	}
+}
+
+func TestBridgeClassifiesDropMidPOST(t *testing.T) {
+	srv := startMockMCPServer(t)
+	proxySrv := startFakeSOCKSProxy(t)
+	proxySrv.DropPOST.Store(true)
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	tb := startTestBridgeWithClient(t, newTestConfig(srv.URL+"/mcp"), d.HTTPClient(5*time.Second), bridge.TransportStreamable)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+	lines := tb.waitLines(t, 1)
+	// Once the tunnel is up, a drop by the proxy cannot be told from one
+	// by the server
+	assertNeutralDrop(t, tb, lines[0])
+	if len(srv.Messages()) != 0 {
+		t.Errorf("server received %d messages, want 0", len(srv.Messages()))
+	}
+}
+
+// assertNeutralDrop checks that line answers request 1 with a dropped
+// connection error that blames neither the proxy nor the server.
+func assertNeutralDrop(t *testing.T, tb *testBridge, line string) {
+	t.Helper()
+	if !strings.Contains(line, `"id":1`) || !strings.Contains(line, "connection dropped before the response") {
+		t.Errorf("expected dropped connection error response, got %s", line)
+	}
+	if logs := tb.logs.String(); strings.Contains(logs, "Cannot connect to SOCKS proxy") || strings.Contains(logs, "Cannot connect to MCP server") {
+		t.Errorf("expected no troubleshooting checklist in logs, got: %s", logs)
+	}
+}
+
+func TestBridgeClassifiesServerCloseAfterRequest(t *testing.T) {
+	// The server reads the whole request, then closes without answering
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	t.Cleanup(srv.Close)
+	proxySrv := startFakeSOCKSProxy(t)
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	tb := startTestBridgeWithClient(t, newTestConfig(srv.URL+"/mcp"), d.HTTPClient(5*time.Second), bridge.TransportStreamable)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+	lines := tb.waitLines(t, 1)
+	assertNeutralDrop(t, tb, lines[0])
+}
+
+func TestBridgeClassifiesResetOfReusedConnection(t *testing.T) {
+	// The first request is answered on a keep-alive connection; the second
+	// one, sent on the same connection, is reset
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		if requests.Add(1) == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}))
+	t.Cleanup(srv.Close)
+	proxySrv := startFakeSOCKSProxy(t)
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	tb := startTestBridgeWithClient(t, newTestConfig(srv.URL+"/mcp"), d.HTTPClient(5*time.Second), bridge.TransportStreamable)
+
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+	lines := tb.waitLines(t, 1)
+	assertNeutralDrop(t, tb, lines[0])
+	if n := len(proxySrv.Targets()); n != 1 {
+		t.Errorf("proxy saw %d connections, want 1 reused connection", n)
+	}
+}
+
+func TestBridgeClassifiesServerDropMidResponse(t *testing.T) {
+	// The server starts answering, then closes the connection
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n")
+		buf.Flush()
+		conn.Close()
+	}))
+	t.Cleanup(srv.Close)
+	proxySrv := startFakeSOCKSProxy(t)
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	tb := startTestBridgeWithClient(t, newTestConfig(srv.URL+"/mcp"), d.HTTPClient(5*time.Second), bridge.TransportStreamable)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+	lines := tb.waitLines(t, 1)
+	if !strings.Contains(lines[0], `"id":1`) || !strings.Contains(lines[0], "server closed the connection mid-response") {
+		t.Errorf("expected server drop error response, got %s", lines[0])
+	}
+	if !strings.Contains(tb.logs.String(), "Cannot connect to MCP server. Please check:") {
+		t.Errorf("expected server checklist in logs, got: %s", tb.logs.String())
+	}
+}
+
+func TestBridgeClassifiesProxyDialFailure(t *testing.T) {
+	// Nothing listens on the proxy address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	proxyAddr := ln.Addr().String()
+	ln.Close()
+	d, err := transport.NewSOCKSDialer(proxyAddr, nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	srv := startMockMCPServer(t)
+	tb := startTestBridgeWithClient(t, newTestConfig(srv.URL+"/mcp"), d.HTTPClient(5*time.Second), bridge.TransportStreamable)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+	lines := tb.waitLines(t, 1)
+	if !strings.Contains(lines[0], `"id":1`) || !strings.Contains(lines[0], "Failed to connect to SOCKS proxy") {
+		t.Errorf("expected proxy dial error response, got %s", lines[0])
+	}
+	if !strings.Contains(tb.logs.String(), "Cannot connect to SOCKS proxy. Please check:") {
+		t.Errorf("expected proxy checklist in logs, got: %s", tb.logs.String())
+	}
+}
+
+func TestBridgeWriteCancelledByShutdown(t *testing.T) {
+	srv := startMockMCPServer(t)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	srv.Respond = func(method string, params json.RawMessage) any {
+		<-release
+		return params
+	}
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.Messages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := tb.stop(t); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if out := tb.stdout.String(); out != "" {
+		t.Errorf("expected no error response on shutdown, got %s", out)
+	}
+	if strings.Contains(tb.logs.String(), "Request timed out") {
+		t.Errorf("shutdown misclassified as timeout: %s", tb.logs.String())
+	}
+}
+
+func TestBridgeDeliversRequestsSentDuringSlowConnect(t *testing.T) {
+	srv := startMockSSEServer(t)
+	srv.EndpointDelay = 300 * time.Millisecond
+	tb := startSSETestBridge(t, newTestConfig(srv.URL+"/sse"))
+
+	// Sent before the endpoint event arrives, while Connect is still pending
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/list","params":{}}`)
+
+	lines := tb.waitLines(t, 2)
+	if !strings.Contains(lines[0], `"id":1`) || !strings.Contains(lines[1], `"id":2`) {
+		t.Errorf("unexpected responses: %q", lines)
+	}
+	msgs := srv.Messages()
+	if len(msgs) != 3 || msgs[0]["method"] != "initialize" || msgs[2]["method"] != "tools/list" {
+		t.Errorf("server received %v, want all three messages in order", msgs)
+	}
+}
+
+func TestBridgeCompat2024UsesEndpointDiscovery(t *testing.T) {
+	srv := startMockSSEServer(t)
+	// Serve the SSE stream at a path that auto-detection takes for Streamable HTTP
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /mcp", func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = "/sse"
+		srv.Config.Handler.ServeHTTP(w, r)
+	})
+	mux.Handle("POST /message", srv.Config.Handler)
+	front := httptest.NewServer(mux)
+	t.Cleanup(front.Close)
+
+	tType, err := bridge.TransportForSpec(config.CompatSSE)
+	if err != nil {
+		t.Fatalf("TransportForSpec() error = %v", err)
+	}
+	if tType != bridge.TransportSSE {
+		t.Fatalf("TransportForSpec(%s) = %s, want %s", config.CompatSSE, tType, bridge.TransportSSE)
+	}
+	tb := startTestBridgeWithClient(t, newTestConfig(front.URL+"/mcp"), &http.Client{}, tType)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{"cursor":"a"}}`)
+	lines := tb.waitLines(t, 1)
+	if lines[0] != `{"jsonrpc":"2.0","id":1,"result":{"cursor":"a"}}` {
+		t.Errorf("unexpected response: %s", lines[0])
+	}
+	// The request went to the endpoint announced on the stream
+	if msgs := srv.waitMessages(t, 1); msgs[0]["method"] != "tools/list" {
+		t.Errorf("server received %v at /message, want tools/list", msgs[0])
+	}
+}
+
+func TestBridgeMaxLineRate(t *testing.T) {
+	srv := startMockSSEServer(t)
+	cfg := newTestConfig(srv.URL + "/sse")
+	cfg.MaxLineRate = 5
+	tb := startSSETestBridge(t, cfg)
+
+	// Wait for the connection before bursting notifications
+	tb.send(t, `{"jsonrpc":"2.0","id":0,"method":"ping"}`)
+	tb.waitLines(t, 1)
+
+	for i := 0; i < 30; i++ {
+		srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/progress", "params": map[string]any{"n": i}})
+	}
+	start := time.Now()
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+
+	// The response passes promptly despite the notification backlog
+	for !strings.Contains(tb.stdout.String(), `"id":1`) {
+		if time.Since(start) > time.Second {
+			t.Fatal("response was delayed behind throttled notifications")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(time.Second - time.Since(start))
+	notifications := strings.Count(tb.stdout.String(), "notifications/progress")
+	// One burst (5) plus one second of refill (5), with slack for timing
+	if notifications > 11 {
+		t.Errorf("%d notifications written in ~1s, want at most ~10 at 5/s", notifications)
+	}
+	if notifications == 0 {
+		t.Error("no notifications written")
+	}
+}
+
+func TestBridgeSpillsThrottledNotificationsToDisk(t *testing.T) {
+	srv := startMockSSEServer(t)
+	cfg := newTestConfig(srv.URL + "/sse")
+	cfg.MaxLineRate = 1e6
+	cfg.NotificationSpillBytes = 1 << 20
+	stdout := &stallWriter{}
+	tb := newTestBridgeWithStdout(t, cfg, &http.Client{}, bridge.TransportSSE, func(w io.Writer) io.Writer {
+		stdout.w = w
+		return stdout
+	})
+	tb.start()
+
+	tb.send(t, `{"jsonrpc":"2.0","id":0,"method":"ping"}`)
+	tb.waitLines(t, 1)
+
+	// Stall the client while the server bursts past the in-memory buffer
+	const n = 600
+	stdout.stall.Lock()
+	for i := 0; i < n; i++ {
+		srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/progress", "params": map[string]any{"n": i}})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(tb.logs.String(), "spilling notifications to disk") {
+		if time.Now().After(deadline) {
+			stdout.stall.Unlock()
+			t.Fatalf("notifications did not spill to disk, logs: %s", tb.logs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stdout.stall.Unlock()
+
+	lines := tb.waitLines(t, 1+n)
+	for i, line := range lines[1:] {
+		var msg struct {
+			Params struct {
+				N int `json:"n"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil || msg.Params.N != i {
+			t.Fatalf("notification %d out of order: %s", i, line)
+		}
+	}
+	if strings.Contains(tb.logs.String(), "Dropping notification") {
+		t.Errorf("notifications were dropped despite the spill file: %s", tb.logs.String())
+	}
+}
+
+func TestBridgeNormalizesJSONRPCVersion(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		return rawReply{"result": map[string]any{"ok": true}}
+	}
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	lines := tb.waitLines(t, 1)
+	var resp struct {
+		JSONRPC string         `json:"jsonrpc"`
+		ID      int            `json:"id"`
+		Result  map[string]any `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", lines[0], err)
+	}
+	if resp.JSONRPC != "2.0" || resp.ID != 1 || resp.Result["ok"] != true {
+		t.Errorf("unexpected response: %s", lines[0])
+	}
+	if !strings.Contains(tb.logs.String(), "WARN: Server sent a message with invalid jsonrpc version") {
+		t.Errorf("expected envelope warning, logs: %s", tb.logs.String())
+	}
+
+	// The connection must survive the malformed envelope
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+	tb.waitLines(t, 2)
+}
+
+func TestBridgeStrictReplacesMalformedEnvelope(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		return rawReply{"jsonrpc": "1.0", "result": map[string]any{"ok": true}}
+	}
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.Strict = true
+	tb := startTestBridge(t, cfg)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":3,"method":"ping"}`)
+	lines := tb.waitLines(t, 1)
+	var resp struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", lines[0], err)
+	}
+	if resp.ID != 3 || resp.Result != nil || resp.Error.Code != bridge.CodeInvalidEnvelope {
+		t.Errorf("unexpected response: %s", lines[0])
+	}
+}
+
+func TestBridgeNormalizesJSONRPCVersionOverSSE(t *testing.T) {
+	srv := startMockSSEServer(t)
+	tb := startSSETestBridge(t, newTestConfig(srv.URL+"/sse"))
+
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	srv.waitMessages(t, 1)
+	srv.PushRaw(`{"method":"notifications/tools/list_changed"}`)
+	srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/resources/list_changed"})
+
+	lines := tb.waitLines(t, 2)
+	if !strings.Contains(lines[0], `"jsonrpc":"2.0"`) || !strings.Contains(lines[0], "tools/list_changed") {
+		t.Errorf("first notification not normalized: %s", lines[0])
+	}
+	if !strings.Contains(lines[1], "resources/list_changed") {
+		t.Errorf("unexpected second notification: %s", lines[1])
+	}
+}
+
+func TestBridgeNormalizeEnvelopeDisabled(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		return rawReply{"jsonrpc": "1.0", "result": map[string]any{"ok": true}}
+	}
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.NormalizeEnvelope = false
+	tb := startTestBridge(t, cfg)
+
+	// The malformed envelope reaches the SDK as is, which drops the
+	// connection
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	select {
+	case <-tb.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the SDK rejected the envelope")
+	}
+	if logs := tb.logs.String(); strings.Contains(logs, "normalizing") || !strings.Contains(logs, "invalid message version") {
+		t.Errorf("envelope not passed on as is, logs: %s", logs)
+	}
+}
+
+func TestBridgeWarmUp(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+	newConns := 0
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			newConns++
+			mu.Unlock()
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.WarmUp = true
+	tb := startTestBridge(t, cfg)
+
+	// The connection must be established before any stdin input
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(methods)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no warm-up request before stdin input")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	tb.waitLines(t, 1)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if methods[0] != http.MethodOptions {
+		t.Errorf("first request method = %s, want OPTIONS", methods[0])
+	}
+	if newConns != 1 {
+		t.Errorf("server saw %d connections, want 1 (warm connection reused)", newConns)
+	}
+}
+
+func TestBridgeReturnsWhenStdinAndServerClose(t *testing.T) {
+	srv := startMockSSEServer(t)
+	tb := startSSETestBridge(t, newTestConfig(srv.URL+"/sse"))
+
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	srv.waitMessages(t, 1)
+	tb.stdin.Close()
+	srv.CloseStream()
+
+	select {
+	case err := <-tb.done:
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after stdin and the server closed")
+	}
+}
+
+func TestBridgeConsumesSSEPingEvents(t *testing.T) {
+	srv := startMockSSEServer(t)
+	tb := startSSETestBridge(t, newTestConfig(srv.URL+"/sse"))
+
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	srv.waitMessages(t, 1)
+	srv.PushFrame("event: ping\n\n")
+	srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/tools/list_changed"})
+	srv.PushFrame("event: ping\ndata: keepalive\n\n")
+	srv.PushFrame(": comment\nevent: ping\ndata: {}\n\n")
+	srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/resources/list_changed"})
+
+	lines := tb.waitLines(t, 2)
+	time.Sleep(50 * time.Millisecond)
+	lines = tb.stdout.Lines()
+	if len(lines) != 2 {
+		t.Fatalf("stdout has %d lines, want 2 messages: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], "tools/list_changed") || !strings.Contains(lines[1], "resources/list_changed") {
+		t.Errorf("unexpected stdout: %q", lines)
+	}
+	if err := tb.stop(t); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestBridgeParsesSSEFieldsPerSpec(t *testing.T) {
+	srv := startMockSSEServer(t)
+	tb := startSSETestBridge(t, newTestConfig(srv.URL+"/sse"))
+
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	srv.waitMessages(t, 1)
+	// Field names without a colon have an empty value
+	srv.PushFrame("data\n\n")
+	srv.PushFrame("id\nretry\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/tools/list_changed\"}\n\n")
+	// Fields may come in any order, and the last event name wins
+	srv.PushFrame("data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/resources/list_changed\"}\nevent: ping\nevent: message\n\n")
+	srv.PushFrame("event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/dropped\"}\nevent: ping\n\n")
+	srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/prompts/list_changed"})
+
+	tb.waitLines(t, 3)
+	time.Sleep(50 * time.Millisecond)
+	lines := tb.stdout.Lines()
+	if len(lines) != 3 {
+		t.Fatalf("stdout has %d lines, want 3 messages: %q", len(lines), lines)
+	}
+	for i, method := range []string{"tools/list_changed", "resources/list_changed", "prompts/list_changed"} {
+		if !strings.Contains(lines[i], method) {
+			t.Errorf("line %d = %s, want %s", i, lines[i], method)
+		}
+	}
+	if err := tb.stop(t); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestBridgeStripsBOMFromSSEData(t *testing.T) {
+	srv := startMockSSEServer(t)
+	tb := startSSETestBridge(t, newTestConfig(srv.URL+"/sse"))
+
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	srv.waitMessages(t, 1)
+	srv.PushFrame("event: message\ndata: \uFEFF {\"jsonrpc\":\"2.0\",\"method\":\"notifications/message\",\"params\":{\"text\":\" kept \"}}\ndata:  \n\n")
+
+	lines := tb.waitLines(t, 1)
+	want := `{"jsonrpc":"2.0","method":"notifications/message","params":{"text":" kept "}}`
+	if lines[0] != want {
+		t.Errorf("stdout line = %s, want %s", lines[0], want)
+	}
+}
+
+func TestBridgeSSEEmptyDataEvents(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{config.SSEEmptyDataDrop, []string{"tools/list_changed"}},
+		{config.SSEEmptyDataForward, []string{bridge.SSEKeepaliveMethod, bridge.SSEKeepaliveMethod, "tools/list_changed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			srv := startMockSSEServer(t)
+			cfg := newTestConfig(srv.URL + "/sse")
+			cfg.SSEEmptyData = tt.policy
+			tb := startSSETestBridge(t, cfg)
+
+			tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+			srv.waitMessages(t, 1)
+			srv.PushFrame("data:\n\n")
+			srv.PushFrame("event: message\ndata: \n\n")
+			srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/tools/list_changed"})
+
+			tb.waitLines(t, len(tt.want))
+			time.Sleep(50 * time.Millisecond)
+			lines := tb.stdout.Lines()
+			if len(lines) != len(tt.want) {
+				t.Fatalf("stdout has %d lines, want %d: %q", len(lines), len(tt.want), lines)
+			}
+			for i, method := range tt.want {
+				if !strings.Contains(lines[i], method) {
+					t.Errorf("line %d = %s, want %s", i, lines[i], method)
+				}
+			}
+			if err := tb.stop(t); err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestBridgeOversizedSSEEvents(t *testing.T) {
+	big := strings.Repeat("x", 4000)
+
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{config.OversizedEventReject, []string{
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"server message of 4060 bytes exceeds the bridge's 1000-byte event limit"}}`,
+		}},
+		{config.OversizedEventTruncate, []string{
+			`{"jsonrpc":"2.0","id":1,"result":{"blob":"...\u003c4000 bytes\u003e","name":"small"}}`,
+		}},
+		{config.OversizedEventSplit, []string{
+			`{"jsonrpc":"2.0","method":"notifications/message","params":{"n":1}}`,
+			`{"jsonrpc":"2.0","method":"notifications/message","params":{"n":2}}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"server message of 4060 bytes exceeds the bridge's 1000-byte event limit"}}`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			srv := startMockSSEServer(t)
+			srv.OnMessage = func(msg map[string]any) any {
+				if tt.policy == config.OversizedEventSplit {
+					// A batch of two small notifications, over the limit together
+					pad := strings.Repeat(" ", 1000)
+					srv.PushRaw(`[{"jsonrpc":"2.0","method":"notifications/message","params":{"n":1}},` + pad +
+						`{"jsonrpc":"2.0","method":"notifications/message","params":{"n":2}}]`)
+				}
+				return map[string]any{"jsonrpc": "2.0", "id": msg["id"], "result": map[string]any{"blob": big, "name": "small"}}
+			}
+			cfg := newTestConfig(srv.URL + "/sse")
+			cfg.MaxEventDataBytes = 1000
+			cfg.OversizedEvent = tt.policy
+			tb := startSSETestBridge(t, cfg)
+
+			tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`)
+			lines := tb.waitLines(t, len(tt.want))
+			for i, want := range tt.want {
+				if lines[i] != want {
+					t.Errorf("line %d = %s, want %s", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestBridgeDisableCapability(t *testing.T) {
+	srv := startMockMCPServer(t)
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.DisableCapabilities = "sampling, roots"
+	tb := startTestBridge(t, cfg)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26",`+
+		`"capabilities":{"sampling":{},"roots":{"listChanged":true},"elicitation":{}},"clientInfo":{"name":"test","version":"1"}}}`)
+	tb.waitLines(t, 1)
+
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(msgs))
+	}
+	params := msgs[0]["params"].(map[string]any)
+	capabilities := params["capabilities"].(map[string]any)
+	for _, name := range []string{"sampling", "roots"} {
+		if _, ok := capabilities[name]; ok {
+			t.Errorf("capability %q was forwarded", name)
+		}
+	}
+	if _, ok := capabilities["elicitation"]; !ok {
+		t.Errorf("capability %q was removed, capabilities: %v", "elicitation", capabilities)
+	}
+	if params["protocolVersion"] != "2025-03-26" || params["clientInfo"] == nil {
+		t.Errorf("other initialize params changed: %v", params)
+	}
+}
+
+func TestBridgeNotificationOutput(t *testing.T) {
+	srv := startMockSSEServer(t)
+	srv.OnMessage = func(msg map[string]any) any {
+		if msg["method"] == "ping" {
+			return map[string]any{"jsonrpc": "2.0", "id": msg["id"], "result": map[string]any{}}
+		}
+		return nil
+	}
+	cfg := newTestConfig(srv.URL + "/sse")
+	stdinR, stdinW := io.Pipe()
+	stdout, notifications := &syncBuffer{}, &syncBuffer{}
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+	b := bridge.NewWithIO(cfg, &http.Client{}, logger, bridge.TransportSSE, stdinR, stdout)
+	b.SetNotificationOutput(notifications)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+	t.Cleanup(func() { stdinW.Close() })
+
+	io.WriteString(stdinW, `{"jsonrpc":"2.0","id":1,"method":"ping"}`+"\n")
+	srv.waitMessages(t, 1)
+	srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/progress", "params": map[string]any{"progress": 1}})
+	srv.Push(map[string]any{"jsonrpc": "2.0", "id": "s1", "method": "sampling/createMessage", "params": map[string]any{}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(stdout.Lines()) < 2 || len(notifications.Lines()) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out; stdout: %q, notifications: %q", stdout.String(), notifications.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if lines := notifications.Lines(); len(lines) != 1 || !strings.Contains(lines[0], "notifications/progress") {
+		t.Errorf("notifications output = %q, want only the progress notification", lines)
+	}
+	for _, line := range stdout.Lines() {
+		if strings.Contains(line, "notifications/progress") {
+			t.Errorf("notification written to stdout: %s", line)
+		}
+	}
+}
+
+func TestBridgeStdinEOFShutsDown(t *testing.T) {
+	srv := startMockMCPServer(t)
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	tb.waitLines(t, 1)
+	tb.stdin.Close()
+
+	select {
+	case err := <-tb.done:
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after stdin was closed")
+	}
+}
+
+func TestBridgeStdinEOFDeliversPendingResponses(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		time.Sleep(200 * time.Millisecond)
+		return params
+	}
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"slow"}}`)
+	tb.stdin.Close()
+
+	select {
+	case err := <-tb.done:
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after stdin was closed")
+	}
+	if lines := tb.stdout.Lines(); len(lines) != 1 || !strings.Contains(lines[0], "slow") {
+		t.Errorf("stdout = %q, want the pending response", lines)
+	}
+}
+
+func TestBridgeHeaderFraming(t *testing.T) {
+	srv := startMockMCPServer(t)
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.Framing = config.FramingHeader
+	tb := startTestBridge(t, cfg)
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{"cursor":"a\nb"}}`
+	// Split the header across writes to exercise partial reads
+	io.WriteString(tb.stdin, "Content-Length: ")
+	io.WriteString(tb.stdin, strconv.Itoa(len(body))+"\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n")
+	io.WriteString(tb.stdin, body)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(tb.stdout.String(), "}}") {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for response, stdout: %q", tb.stdout.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out := tb.stdout.String()
+	header, payload, ok := strings.Cut(out, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("response has no header block: %q", out)
+	}
+	if want := "Content-Length: " + strconv.Itoa(len(payload)); header != want {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	var resp struct {
+		ID     int            `json:"id"`
+		Result map[string]any `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(payload), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", payload, err)
+	}
+	if resp.ID != 1 || resp.Result["cursor"] != "a\nb" {
+		t.Errorf("unexpected response: %s", payload)
+	}
+}
+
+func TestBridgePreservesClientMessageOrder(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		if method == "initialize" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return params
+	}
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	tb.waitLines(t, 2)
+
+	var methods []string
+	for _, msg := range srv.Messages() {
+		methods = append(methods, msg["method"].(string))
+	}
+	want := []string{"initialize", "notifications/initialized", "tools/list"}
+	if strings.Join(methods, ",") != strings.Join(want, ",") {
+		t.Errorf("server received %v, want %v", methods, want)
+	}
+}
+
+func TestBridgeCompactsMultiLineServerMessages(t *testing.T) {
+	srv := startMockSSEServer(t)
+	tb := startSSETestBridge(t, newTestConfig(srv.URL+"/sse"))
+
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	srv.waitMessages(t, 1)
+	srv.PushFrame("event: message\n" +
+		"data: {\n" +
+		"data:   \"jsonrpc\": \"2.0\",\n" +
+		"data:   \"method\": \"notifications/message\",\n" +
+		"data:   \"params\": {\"text\": \"line one\\nline two\",  \"level\":  \"info\"}\n" +
+		"data: }\n\n")
+
+	lines := tb.waitLines(t, 1)
+	want := `{"jsonrpc":"2.0","method":"notifications/message","params":{"text":"line one\nline two","level":"info"}}`
+	if lines[0] != want {
+		t.Errorf("stdout line = %s, want %s", lines[0], want)
+	}
+}
+
+func TestBridgeWarnsOnProtocolMismatch(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		return map[string]any{"protocolVersion": "2024-11-05", "capabilities": map[string]any{}}
+	}
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.WarnProtocolMismatch = true
+	tb := startTestBridge(t, cfg)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{}}}`)
+	tb.waitLines(t, 1)
+
+	if !strings.Contains(tb.logs.String(), "WARN: Server negotiated MCP protocol version 2024-11-05, but the client requested 2025-03-26") {
+		t.Errorf("expected protocol mismatch warning, logs: %s", tb.logs.String())
+	}
+	if got := tb.Stats().Snapshot().ProtocolVersion; got != "2024-11-05" {
+		t.Errorf("Snapshot().ProtocolVersion = %q, want %q", got, "2024-11-05")
+	}
+}
+
+func TestBridgeValidateParams(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		if method == "tools/list" {
+			return map[string]any{"tools": []any{
+				map[string]any{
+					"name": "add",
+					"inputSchema": map[string]any{
+						"type":       "object",
+						"properties": map[string]any{"a": map[string]any{"type": "number"}, "b": map[string]any{"type": "number"}},
+						"required":   []string{"a", "b"},
+					},
+				},
+			}}
+		}
+		return map[string]any{"content": []any{}}
+	}
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.ValidateParams = true
+	tb := startTestBridge(t, cfg)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	tb.waitLines(t, 1)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"add","arguments":{"a":1,"b":"two"}}}`)
+	lines := tb.waitLines(t, 2)
+	var resp struct {
+		ID    int `json:"id"`
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", lines[1], err)
+	}
+	if resp.ID != 2 || resp.Error.Code != bridge.CodeInvalidParams {
+		t.Errorf("unexpected response: %s", lines[1])
+	}
+
+	// Valid calls, and calls to tools without a schema, are forwarded
+	tb.send(t, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"add","arguments":{"a":1,"b":2}}}`)
+	tb.send(t, `{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"unknown"}}`)
+	tb.waitLines(t, 4)
+
+	var forwarded []any
+	for _, msg := range srv.Messages() {
+		if msg["method"] == "tools/call" {
+			forwarded = append(forwarded, msg["id"])
+		}
+	}
+	if len(forwarded) != 2 || forwarded[0] != float64(3) || forwarded[1] != float64(4) {
+		t.Errorf("forwarded tools/call ids = %v, want [3 4]", forwarded)
+	}
+}
+
+func TestBridgeCancelOnSignal(t *testing.T) {
+	srv := startMockMCPServer(t)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	srv.Respond = func(method string, params json.RawMessage) any {
+		<-release
+		return params
+	}
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.CancelOnSignal = true
+	tb := startTestBridge(t, cfg)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":"slow-1","method":"tools/call","params":{"name":"slow"}}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.Messages()) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not receive the request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Cancelling Run's context is what the signal handler does
+	if err := tb.stop(t); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+
+	msgs := srv.Messages()
+	last := msgs[len(msgs)-1]
+	if last["method"] != "notifications/cancelled" {
+		t.Fatalf("last server message = %v, want notifications/cancelled", last)
+	}
+	if params := last["params"].(map[string]any); params["requestId"] != "slow-1" {
+		t.Errorf("cancelled requestId = %v, want %q", params["requestId"], "slow-1")
+	}
+}
+
+func TestBridgeHandlesMethodsLocally(t *testing.T) {
+	srv := startMockMCPServer(t)
+	cfg := newTestConfig(srv.URL + "/mcp")
+	stdinR, stdinW := io.Pipe()
+	stdout := &syncBuffer{}
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+	b := bridge.NewWithIO(cfg, &http.Client{}, logger, bridge.TransportStreamable, stdinR, stdout)
+	mirrored := &syncBuffer{}
+	b.SetMirror(bridge.NewMirror(mirrored, logger))
+	if err := b.UseBuiltinHandler("ping"); err != nil {
+		t.Fatalf("UseBuiltinHandler() error = %v", err)
+	}
+	b.HandleLocally("custom/echo", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return params, nil
+	})
+	b.HandleLocally("custom/fail", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return nil, errors.New("not today")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+	t.Cleanup(func() { stdinW.Close() })
+
+	for _, line := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"ping"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"custom/echo","params":{"x":1}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"custom/fail"}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/list"}`,
+	} {
+		io.WriteString(stdinW, line+"\n")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(stdout.Lines()) < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out; stdout: %q", stdout.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	lines := stdout.Lines()
+	want := []string{
+		`{"id":1,"jsonrpc":"2.0","result":{}}`,
+		`{"id":2,"jsonrpc":"2.0","result":{"x":1}}`,
+	}
+	for i, w := range want {
+		if lines[i] != w {
+			t.Errorf("line %d = %s, want %s", i, lines[i], w)
+		}
+	}
+	if !strings.Contains(lines[2], `"id":3`) || !strings.Contains(lines[2], "not today") {
+		t.Errorf("expected error response from failing handler, got %s", lines[2])
+	}
+
+	msgs := srv.Messages()
+	if len(msgs) != 1 || msgs[0]["method"] != "tools/list" {
+		t.Errorf("server received %v, want only tools/list", msgs)
+	}
+
+	// Local results are counted and mirrored like server responses
+	for len(mirrored.Lines()) < 3 || b.Stats().Snapshot().MessagesReceived < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("mirror = %q, stats = %+v, want 3 messages received", mirrored.String(), b.Stats().Snapshot())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := mirrored.Lines(); got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("mirror = %q, want the local results first", got)
+	}
+	if stats := b.Stats().Snapshot(); stats.MessagesSent != 1 || stats.MessagesReceived != 3 || stats.Errors != 1 {
+		t.Errorf("Snapshot() = %+v, want 1 sent, 3 received, 1 error", stats)
+	}
+}
+
+func TestBridgeUseBuiltinHandlerUnknown(t *testing.T) {
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+	b := bridge.NewWithIO(newTestConfig("http://example.com/mcp"), &http.Client{}, logger, bridge.TransportStreamable, strings.NewReader(""), io.Discard)
+	if err := b.UseBuiltinHandler("tools/list"); err == nil {
+		t.Error("UseBuiltinHandler(tools/list) expected error")
+	}
+}
+
+func TestBridgeOnDuplicateID(t *testing.T) {
+	tests := []struct {
+		policy      string
+		wantForward int
+	}{
+		{config.DuplicateIDAllow, 2},
+		{config.DuplicateIDReject, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			// The SSE server accepts requests without answering them, so
+			// the first stays in flight
+			srv := startMockSSEServer(t)
+			srv.OnMessage = func(msg map[string]any) any { return nil }
+			cfg := newTestConfig(srv.URL + "/sse")
+			cfg.OnDuplicateID = tt.policy
+			tb := startSSETestBridge(t, cfg)
+
+			tb.send(t, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"n":1}}`)
+			srv.waitMessages(t, 1)
+			tb.send(t, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"n":2}}`)
+
+			if tt.policy == config.DuplicateIDReject {
+				lines := tb.waitLines(t, 1)
+				if !strings.Contains(lines[0], `"id":7`) || !strings.Contains(lines[0], "-32600") || !strings.Contains(lines[0], "duplicate id in flight") {
+					t.Errorf("expected duplicate id error, got %s", lines[0])
+				}
+				// Give a wrongly forwarded request time to arrive
+				time.Sleep(50 * time.Millisecond)
+			} else {
+				srv.waitMessages(t, 2)
+			}
+			if got := len(srv.Messages()); got != tt.wantForward {
+				t.Errorf("server received %d requests, want %d", got, tt.wantForward)
+			}
+		})
+	}
+}
+
+// shortWriter accepts at most max bytes per Write, reporting io.ErrShortWrite
+// for the rest. After failAfter bytes in total (if positive), it fails.
+type shortWriter struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	failAfter int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failAfter > 0 && w.buf.Len() >= w.failAfter {
+		return 0, io.ErrClosedPipe
+	}
+	if len(p) <= w.max {
+		return w.buf.Write(p)
+	}
+	w.buf.Write(p[:w.max])
+	return w.max, io.ErrShortWrite
+}
+
+func (w *shortWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// stallWriter passes writes on to w, blocking while stall is held.
+type stallWriter struct {
+	stall sync.Mutex
+	w     io.Writer
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	w.stall.Lock()
+	w.stall.Unlock()
+	return w.w.Write(p)
+}
+
+func TestBridgeRetriesShortStdoutWrites(t *testing.T) {
+	srv := startMockMCPServer(t)
+	stdinR, stdinW := io.Pipe()
+	stdout := &shortWriter{max: 7}
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+	b := bridge.NewWithIO(newTestConfig(srv.URL+"/mcp"), &http.Client{}, logger, bridge.TransportStreamable, stdinR, stdout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+	t.Cleanup(func() { stdinW.Close() })
+
+	io.WriteString(stdinW, `{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{"cursor":"a"}}`+"\n")
+	want := `{"jsonrpc":"2.0","id":1,"result":{"cursor":"a"}}` + "\n"
+	deadline := time.Now().Add(5 * time.Second)
+	for stdout.String() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("stdout = %q, want %q", stdout.String(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBridgeShutsDownOnStdoutWriteError(t *testing.T) {
+	srv := startMockMCPServer(t)
+	stdinR, stdinW := io.Pipe()
+	stdout := &shortWriter{max: 10, failAfter: 10}
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+	b := bridge.NewWithIO(newTestConfig(srv.URL+"/mcp"), &http.Client{}, logger, bridge.TransportStreamable, stdinR, stdout)
+
+	done := make(chan error, 1)
+	go func() { done <- b.Run(context.Background()) }()
+	t.Cleanup(func() { stdinW.Close() })
+
+	io.WriteString(stdinW, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`+"\n")
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "stdout") {
+			t.Errorf("Run() error = %v, want stdout write error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bridge did not shut down after a stdout write error")
+	}
+
+	// Nothing is written after the failed message
+	if got := stdout.String(); len(got) != 10 {
+		t.Errorf("stdout = %q, want only the 10 bytes written before the failure", got)
+	}
+}
+
+func TestBridgeLogMaxString(t *testing.T) {
+	srv := startMockMCPServer(t)
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.LogMaxString = 64
+	tb := startTestBridge(t, cfg)
+
+	blob := strings.Repeat("QUJD", 256*1024) // 1MB of base64
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"resources/write","params":{"uri":"file:///a.png","blob":"`+blob+`"}}`)
+	lines := tb.waitLines(t, 1)
+
+	// Forwarded intact in both directions
+	msgs := srv.Messages()
+	if params := msgs[0]["params"].(map[string]any); params["blob"] != blob || params["uri"] != "file:///a.png" {
+		t.Error("request forwarded to the server was altered")
+	}
+	if !strings.Contains(lines[0], blob) {
+		t.Error("response written to stdout was altered")
+	}
+
+	logs := tb.logs.String()
+	if strings.Contains(logs, blob) {
+		t.Error("debug log contains the full blob")
+	}
+	if !strings.Contains(logs, `"blob":"...<1048576 bytes>"`) || !strings.Contains(logs, `"uri":"file:///a.png"`) {
+		t.Errorf("debug log does not show the abbreviated message: %.500s", logs)
+	}
+}
+
+func TestBridgeWarnsOnUnsupportedServerProtocolVersion(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		return map[string]any{"protocolVersion": "2099-01-01", "capabilities": map[string]any{}}
+	}
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{}}}`)
+	lines := tb.waitLines(t, 1)
+
+	// The response is still forwarded unchanged
+	if !strings.Contains(lines[0], `"protocolVersion":"2099-01-01"`) {
+		t.Errorf("unexpected response: %s", lines[0])
+	}
+	logs := tb.logs.String()
+	if !strings.Contains(logs, "WARN: Server negotiated MCP protocol version 2099-01-01, which this bridge does not support (client requested 2025-03-26") {
+		t.Errorf("expected unsupported version warning, logs: %s", logs)
+	}
+}
+
+func TestBridgeNoBatch(t *testing.T) {
+	const batch = `[{"jsonrpc":"2.0","id":1,"method":"tools/list"},{"jsonrpc":"2.0","id":2,"method":"tools/list"}]`
+
+	t.Run("rejected", func(t *testing.T) {
+		srv := startMockMCPServer(t)
+		cfg := newTestConfig(srv.URL + "/mcp")
+		cfg.NoBatch = true
+		tb := startTestBridge(t, cfg)
+
+		tb.send(t, batch)
+		tb.send(t, `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+		lines := tb.waitLines(t, 2)
+		if lines[0] != `{"error":{"code":-32600,"message":"batch requests are not accepted"},"id":null,"jsonrpc":"2.0"}` {
+			t.Errorf("unexpected batch response: %s", lines[0])
+		}
+		if !strings.Contains(lines[1], `"id":3`) {
+			t.Errorf("unexpected response after batch: %s", lines[1])
+		}
+		if n := len(srv.Messages()); n != 1 {
+			t.Errorf("server received %d messages, want 1", n)
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		srv := startMockMCPServer(t)
+		tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+		tb.send(t, batch)
+		tb.send(t, `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+		lines := tb.waitLines(t, 1)
+		if strings.Contains(lines[0], "batch requests are not accepted") {
+			t.Errorf("batch rejected without --no-batch: %s", lines[0])
+		}
+	})
+}
+
+func TestBridgeSendsNegotiatedProtocolVersion(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		if method == "initialize" {
+			return map[string]any{"protocolVersion": "2025-03-26", "capabilities": map[string]any{}}
+		}
+		return map[string]any{}
+	}
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{}}}`)
+	tb.waitLines(t, 1)
+	tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	tb.waitLines(t, 2)
+	tb.stop(t)
+
+	headers := srv.Headers()
+	if len(headers) != 3 {
+		t.Fatalf("server received %d requests, want 3", len(headers))
+	}
+	if got := headers[0].Get("MCP-Protocol-Version"); got != "" {
+		t.Errorf("initialize MCP-Protocol-Version = %q, want none", got)
+	}
+	for i, h := range headers[1:] {
+		if got := h.Get("MCP-Protocol-Version"); got != "2025-03-26" {
+			t.Errorf("request %d MCP-Protocol-Version = %q, want %q", i+2, got, "2025-03-26")
+		}
+	}
+}
+
+func TestBridgeMaxClientResponseBytes(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		if method == "resources/read" {
+			return map[string]any{"blob": strings.Repeat("x", 2048)}
+		}
+		return map[string]any{}
+	}
+	cfg := newTestConfig(srv.URL + "/mcp")
+	cfg.MaxClientResponseBytes = 1024
+	tb := startTestBridge(t, cfg)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"resources/read"}`)
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	lines := tb.waitLines(t, 2)
+
+	want := `{"error":{"code":-32000,"message":"response of 2093 bytes exceeds the client limit of 1024 bytes"},"id":1,"jsonrpc":"2.0"}`
+	if lines[0] != want {
+		t.Errorf("oversized response = %s, want %s", lines[0], want)
+	}
+	if lines[1] != `{"jsonrpc":"2.0","id":2,"result":{}}` {
+		t.Errorf("small response = %s", lines[1])
+	}
+}
+
+func TestBridgeSingleShot(t *testing.T) {
+	srv := startMockMCPServer(t)
+	srv.Respond = func(method string, params json.RawMessage) any {
+		if method == "tools/call" {
+			return rpcError{Code: -32602, Message: "unknown tool"}
+		}
+		return params
+	}
+
+	waitExit := func(t *testing.T, tb *testBridge) error {
+		t.Helper()
+		select {
+		case err := <-tb.done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("bridge did not exit after the single-shot response")
+			return nil
+		}
+	}
+
+	t.Run("exits cleanly after the response", func(t *testing.T) {
+		cfg := newTestConfig(srv.URL + "/mcp")
+		cfg.SingleShot = true
+		tb := startTestBridge(t, cfg)
+
+		// Stdin stays open; later messages are not forwarded
+		tb.send(t, `{"jsonrpc":"2.0","id":"a","method":"tools/list","params":{"n":1}}`+"\n"+
+			`{"jsonrpc":"2.0","id":"b","method":"tools/list","params":{"n":2}}`)
+		if err := waitExit(t, tb); err != nil {
+			t.Fatalf("Run() error = %v, want nil", err)
+		}
+		lines := tb.stdout.Lines()
+		if len(lines) != 1 || !strings.Contains(lines[0], `"id":"a"`) || !strings.Contains(lines[0], `"result":{"n":1}`) {
+			t.Errorf("stdout = %q, want only the response to the first request", lines)
+		}
+		for _, msg := range srv.Messages() {
+			if msg["id"] == "b" {
+				t.Error("second request was forwarded in single-shot mode")
+			}
+		}
+	})
+
+	t.Run("fails on an error response", func(t *testing.T) {
+		cfg := newTestConfig(srv.URL + "/mcp")
+		cfg.SingleShot = true
+		tb := startTestBridge(t, cfg)
+
+		tb.send(t, `{"jsonrpc":"2.0","id":7,"method":"tools/call"}`)
+		err := waitExit(t, tb)
+		if err == nil || !strings.Contains(err.Error(), "unknown tool") {
+			t.Errorf("Run() error = %v, want the response's error", err)
+		}
+		if lines := tb.stdout.Lines(); len(lines) != 1 || !strings.Contains(lines[0], `"id":7`) {
+			t.Errorf("stdout = %q, want the error response", lines)
+		}
+	})
+
+	t.Run("answers a timeout once", func(t *testing.T) {
+		slow := startMockMCPServer(t)
+		slow.Respond = func(method string, params json.RawMessage) any {
+			time.Sleep(300 * time.Millisecond)
+			return params
+		}
+		cfg := newTestConfig(slow.URL + "/mcp")
+		cfg.SingleShot = true
+		cfg.Timeout = 100 * time.Millisecond
+		tb := startTestBridge(t, cfg)
+
+		tb.send(t, `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+		err := waitExit(t, tb)
+		if err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("Run() error = %v, want a timeout", err)
+		}
+		time.Sleep(400 * time.Millisecond)
+		if lines := tb.stdout.Lines(); len(lines) != 1 || !strings.Contains(lines[0], `"id":3`) {
+			t.Errorf("stdout = %q, want one error response", lines)
+		}
+	})
+
+	t.Run("fails when stdin closes first", func(t *testing.T) {
+		cfg := newTestConfig(srv.URL + "/mcp")
+		cfg.SingleShot = true
+		tb := startTestBridge(t, cfg)
+
+		tb.stdin.Close()
+		if err := waitExit(t, tb); err == nil {
+			t.Error("Run() error = nil, want an error for no request")
+		}
+	})
+}
+
+func TestShutdownRunsStepsInOrder(t *testing.T) {
+	logs := &syncBuffer{}
+	logger := logging.NewWithWriter(logging.LogLevelDebug, logs)
+	var mu sync.Mutex
+	var ran []string
+	step := func(name string, run func(ctx context.Context) error) bridge.ShutdownStep {
+		return bridge.ShutdownStep{Name: name, Run: func(ctx context.Context) error {
+			mu.Lock()
+			ran = append(ran, name)
+			mu.Unlock()
+			return run(ctx)
+		}}
+	}
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	start := time.Now()
+	bridge.Shutdown([]bridge.ShutdownStep{
+		step("write recording", func(ctx context.Context) error { return nil }),
+		step("write metrics file", func(ctx context.Context) error { return errors.New("disk full") }),
+		// Ignores ctx, so it must be abandoned
+		step("write pending messages to the mirror", func(ctx context.Context) error { <-stuck; return nil }),
+		step("deliver pending webhook events", func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() }),
+		step("flush traces", func(ctx context.Context) error { return nil }),
+	}, 100*time.Millisecond, logger)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Shutdown took %v, want each step bounded by its timeout", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"write recording", "write metrics file", "write pending messages to the mirror", "deliver pending webhook events", "flush traces"}
+	if !slices.Equal(ran, want) {
+		t.Errorf("steps ran %q, want %q", ran, want)
+	}
+	for _, msg := range []string{
+		"WARN: Failed to write metrics file: disk full",
+		"WARN: Failed to write pending messages to the mirror: context deadline exceeded",
+		"WARN: Failed to deliver pending webhook events: context deadline exceeded",
+	} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Errorf("expected %q in logs: %s", msg, logs.String())
+		}
+	}
+}
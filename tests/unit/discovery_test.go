@@ -0,0 +1,101 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/echo"
+)
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name          string
+		doc           string // "" serves 404
+		wantTransport bridge.TransportType
+		wantPath      string
+		wantNil       bool
+		wantErr       bool
+	}{
+		{
+			name:          "sse",
+			doc:           `{"transport":"sse","sse_endpoint":"/events","message_endpoint":"/messages"}`,
+			wantTransport: bridge.TransportSSE,
+			wantPath:      "/events",
+		},
+		{
+			name:          "streamable",
+			doc:           `{"transport":"streamable-http","message_endpoint":"/api/mcp"}`,
+			wantTransport: bridge.TransportStreamable,
+			wantPath:      "/api/mcp",
+		},
+		{name: "no document", wantNil: true},
+		{name: "unknown transport", doc: `{"transport":"websocket","message_endpoint":"/ws"}`, wantErr: true},
+		{name: "missing endpoint", doc: `{"transport":"sse"}`, wantErr: true},
+		{name: "invalid json", doc: `{`, wantErr: true},
+		{name: "cross-origin endpoint", doc: `{"transport":"streamable","message_endpoint":"http://evil.example/mcp"}`, wantErr: true},
+		{name: "other scheme", doc: `{"transport":"streamable","message_endpoint":"https://127.0.0.1/mcp"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/.well-known/mcp" || tt.doc == "" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.doc))
+			}))
+			defer srv.Close()
+
+			d, err := bridge.Discover(context.Background(), srv.Client(), srv.URL+"/some/base")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Discover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantNil {
+				if d != nil {
+					t.Errorf("Discover() = %+v, want nil", d)
+				}
+				return
+			}
+			if d.Transport != tt.wantTransport {
+				t.Errorf("Transport = %s, want %s", d.Transport, tt.wantTransport)
+			}
+			if want := srv.URL + tt.wantPath; d.Endpoint != want {
+				t.Errorf("Endpoint = %s, want %s", d.Endpoint, want)
+			}
+		})
+	}
+}
+
+func TestDiscoverDrivesTransportSelection(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/mcp", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"transport":"streamable","message_endpoint":"/rpc"}`))
+	})
+	mux.Handle("/rpc", echo.NewStreamableHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// The URL alone would be auto-detected as SSE
+	d, err := bridge.Discover(context.Background(), srv.Client(), srv.URL)
+	if err != nil || d == nil {
+		t.Fatalf("Discover() = %v, %v", d, err)
+	}
+
+	cfg := newTestConfig(d.Endpoint)
+	tb := startTestBridgeWithClient(t, cfg, &http.Client{}, d.Transport)
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+	lines := tb.waitLines(t, 1)
+	if !strings.Contains(lines[0], echo.ServerName) {
+		t.Errorf("initialize response = %s, want echo server info", lines[0])
+	}
+}
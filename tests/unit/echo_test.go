@@ -0,0 +1,72 @@
+package unit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/echo"
+)
+
+func TestBridgeWithEchoServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.Handler
+		path    string
+		tType   bridge.TransportType
+	}{
+		{"sse", echo.NewSSEHandler(), "/sse", bridge.TransportSSE},
+		{"streamable", echo.NewStreamableHandler(), "/mcp", bridge.TransportStreamable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			cfg := newTestConfig(srv.URL + tt.path)
+			tb := startTestBridgeWithClient(t, cfg, &http.Client{}, tt.tType)
+
+			tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+			tb.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+			tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"echo"}}`)
+			lines := tb.waitLines(t, 2)
+
+			var initResp struct {
+				ID     int `json:"id"`
+				Result struct {
+					ProtocolVersion string `json:"protocolVersion"`
+					ServerInfo      struct {
+						Name string `json:"name"`
+					} `json:"serverInfo"`
+				} `json:"result"`
+			}
+			if err := json.Unmarshal([]byte(lines[0]), &initResp); err != nil {
+				t.Fatalf("invalid response %q: %v", lines[0], err)
+			}
+			if initResp.ID != 1 || initResp.Result.ProtocolVersion != "2025-03-26" || initResp.Result.ServerInfo.Name != echo.ServerName {
+				t.Errorf("unexpected initialize response: %s", lines[0])
+			}
+
+			var echoResp struct {
+				ID     int `json:"id"`
+				Result struct {
+					Method string         `json:"method"`
+					Params map[string]any `json:"params"`
+				} `json:"result"`
+			}
+			if err := json.Unmarshal([]byte(lines[1]), &echoResp); err != nil {
+				t.Fatalf("invalid response %q: %v", lines[1], err)
+			}
+			if echoResp.ID != 2 || echoResp.Result.Method != "tools/call" || echoResp.Result.Params["name"] != "echo" {
+				t.Errorf("unexpected echo response: %s", lines[1])
+			}
+
+			if err := tb.stop(t); err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/config"
+	"github.com/iiharu/mcp-over-socks/internal/transport"
+)
+
+func TestHeaderTransportClientInfo(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{ClientPID: 4242, ClientName: "cursor"}
+	client := &http.Client{
+		Transport: transport.NewHeaderTransport(http.DefaultTransport, cfg.ClientHeaders()),
+	}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if v := got.Get("X-MCP-Client-PID"); v != "4242" {
+		t.Errorf("X-MCP-Client-PID = %q, want %q", v, "4242")
+	}
+	if v := got.Get("X-MCP-Client-Name"); v != "cursor" {
+		t.Errorf("X-MCP-Client-Name = %q, want %q", v, "cursor")
+	}
+}
+
+func TestConfigClientHeadersOptIn(t *testing.T) {
+	cfg := &config.Config{}
+	if headers := cfg.ClientHeaders(); len(headers) != 0 {
+		t.Errorf("ClientHeaders() = %v, want none when unconfigured", headers)
+	}
+}
+
+func TestConfigLoadHeadersFile(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "headers")
+	content := "# Upstream gateway\n" +
+		"X-Tenant: acme\n" +
+		"\n" +
+		"Authorization: Bearer from-file\n" +
+		"  X-Request-Source:   bridge  \n" +
+		"X-Trace: a:b:c\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		HeadersFile:   path,
+		ServerHeaders: []string{"Authorization: Bearer from-flag"},
+	}
+	if err := cfg.LoadHeadersFile(); err != nil {
+		t.Fatalf("LoadHeadersFile() error = %v", err)
+	}
+	headers, err := cfg.ServerHeaderMap()
+	if err != nil {
+		t.Fatalf("ServerHeaderMap() error = %v", err)
+	}
+	client := &http.Client{Transport: transport.NewHeaderTransport(http.DefaultTransport, headers)}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	want := map[string]string{
+		"X-Tenant":         "acme",
+		"Authorization":    "Bearer from-flag", // the flag overrides the file
+		"X-Request-Source": "bridge",
+		"X-Trace":          "a:b:c",
+	}
+	for name, value := range want {
+		if v := got.Get(name); v != value {
+			t.Errorf("%s = %q, want %q", name, v, value)
+		}
+	}
+}
+
+func TestConfigServerHeaderMapMixedCase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers")
+	if err := os.WriteFile(path, []byte("authorization: Bearer from-file\nx-tenant: acme\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		HeadersFile:   path,
+		ServerHeaders: []string{"AUTHORIZATION: Bearer from-flag"},
+	}
+	if err := cfg.LoadHeadersFile(); err != nil {
+		t.Fatalf("LoadHeadersFile() error = %v", err)
+	}
+	headers, err := cfg.ServerHeaderMap()
+	if err != nil {
+		t.Fatalf("ServerHeaderMap() error = %v", err)
+	}
+
+	// The flag overrides the file whatever the case of either name
+	want := map[string]string{
+		"Authorization": "Bearer from-flag",
+		"X-Tenant":      "acme",
+	}
+	if len(headers) != len(want) {
+		t.Errorf("ServerHeaderMap() = %v, want %v", headers, want)
+	}
+	for name, value := range want {
+		if headers[name] != value {
+			t.Errorf("ServerHeaderMap()[%q] = %q, want %q", name, headers[name], value)
+		}
+	}
+}
+
+func TestConfigLoadHeadersFileInvalidLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers")
+	if err := os.WriteFile(path, []byte("X-Ok: 1\nnot a header\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{HeadersFile: path}
+	err := cfg.LoadHeadersFile()
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("LoadHeadersFile() error = %v, want error for line 2", err)
+	}
+}
+
+// signingTransport is a RoundTripper that adds a signature header.
+type signingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Signature", "signed:"+req.Method)
+	return t.base.RoundTrip(req)
+}
+
+func TestSOCKSDialerRoundTripperDecorator(t *testing.T) {
+	srv := startMockMCPServer(t)
+	proxySrv := startFakeSOCKSProxy(t)
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	d.SetRoundTripperDecorator(func(base http.RoundTripper) http.RoundTripper {
+		if _, ok := base.(*http.Transport); !ok {
+			t.Errorf("decorated transport is %T, want the SOCKS *http.Transport", base)
+		}
+		return &signingTransport{base: base}
+	})
+
+	tb := startTestBridgeWithClient(t, newTestConfig(srv.URL+"/mcp"), d.HTTPClient(5*time.Second), bridge.TransportStreamable)
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	tb.waitLines(t, 1)
+
+	headers := srv.Headers()
+	if len(headers) == 0 || headers[0].Get("X-Signature") != "signed:POST" {
+		t.Errorf("server headers = %v, want X-Signature from the decorator", headers)
+	}
+	if len(proxySrv.Targets()) == 0 {
+		t.Error("request did not go through the SOCKS proxy")
+	}
+}
+
+func TestSOCKSDialerMaxResponseHeaderBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Mcp-Session-Id", strings.Repeat("s", 64*1024))
+	}))
+	defer srv.Close()
+	proxySrv := startFakeSOCKSProxy(t)
+
+	for _, tt := range []struct {
+		limit  int64
+		wantOK bool
+	}{
+		{16 * 1024, false},
+		{128 * 1024, true},
+	} {
+		d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+		if err != nil {
+			t.Fatalf("NewSOCKSDialer() error = %v", err)
+		}
+		d.SetMaxResponseHeaderBytes(tt.limit)
+
+		resp, err := d.HTTPClient(5 * time.Second).Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tt.wantOK {
+			t.Errorf("limit %d: Get() error = %v, want success %v", tt.limit, err, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+func TestServeListener(t *testing.T) {
+	srv := startMockMCPServer(t)
+	cfg := newTestConfig(srv.URL + "/mcp")
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- bridge.ServeListener(ctx, ln, logger, func(ctx context.Context, conn net.Conn) error {
+			return bridge.NewWithIO(cfg, &http.Client{}, logger, bridge.TransportStreamable, conn, conn).Run(ctx)
+		})
+	}()
+
+	// Each client gets its own session, one after the other
+	for _, id := range []string{"1", "2"} {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, `{"jsonrpc":"2.0","id":`+id+`,"method":"tools/list","params":{"cursor":"c`+id+`"}}`+"\n")
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if want := `{"jsonrpc":"2.0","id":` + id + `,"result":{"cursor":"c` + id + `"}}` + "\n"; line != want {
+			t.Errorf("response = %q, want %q", line, want)
+		}
+		conn.Close()
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ServeListener() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeListener did not return after cancellation")
+	}
+}
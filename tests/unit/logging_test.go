@@ -0,0 +1,46 @@
+package unit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		input string
+		want  logging.LogLevel
+	}{
+		{"error", logging.LogLevelError},
+		{"warn", logging.LogLevelWarn},
+		{"info", logging.LogLevelInfo},
+		{"debug", logging.LogLevelDebug},
+		{"bogus", logging.LogLevelInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := logging.ParseLogLevel(tt.input); got != tt.want {
+				t.Errorf("ParseLogLevel(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoggerWarnLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.NewWithWriter(logging.LogLevelWarn, &buf)
+
+	logger.Info("hidden")
+	logger.Warn("shown %d", 1)
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("info message logged at warn level: %q", out)
+	}
+	if !strings.Contains(out, "WARN: shown 1") {
+		t.Errorf("warn message missing: %q", out)
+	}
+}
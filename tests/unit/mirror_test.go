@@ -0,0 +1,151 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// closeMirror stops the bridge, then the mirror, as main does.
+func closeMirror(t *testing.T, tb *testBridge, mirror *bridge.Mirror) {
+	t.Helper()
+	tb.stop(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := mirror.Close(ctx); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestBridgeMirrorsServerMessages(t *testing.T) {
+	srv := startMockMCPServer(t)
+
+	// A monitor listening on a Unix socket
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "mirror.sock"))
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	mirrored := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			mirrored <- scanner.Text()
+		}
+	}()
+	sink, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial mirror: %v", err)
+	}
+	defer sink.Close()
+
+	tb := newTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+	mirror := bridge.NewMirror(sink, logging.NewWithWriter(logging.LogLevelError, io.Discard))
+	tb.SetMirror(mirror)
+	tb.start()
+	defer closeMirror(t, tb, mirror)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{"cursor":"a"}}`)
+	lines := tb.waitLines(t, 1)
+
+	select {
+	case got := <-mirrored:
+		if got != lines[0] {
+			t.Errorf("mirrored %s, want %s", got, lines[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message did not reach the mirror")
+	}
+}
+
+func TestOpenMirrorSinkFileMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirror.jsonl")
+	sink, err := bridge.OpenMirrorSink(path)
+	if err != nil {
+		t.Fatalf("OpenMirrorSink() error = %v", err)
+	}
+	sink.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat mirror: %v", err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0o600 {
+		t.Errorf("mirror file mode = %v, want %v", mode, os.FileMode(0o600))
+	}
+}
+
+func TestBridgeMirrorFailureDoesNotAffectStdout(t *testing.T) {
+	srv := startMockMCPServer(t)
+	client, server := net.Pipe()
+	server.Close()
+
+	tb := newTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+	mirror := bridge.NewMirror(client, logging.NewWithWriter(logging.LogLevelError, io.Discard))
+	tb.SetMirror(mirror)
+	tb.start()
+	defer closeMirror(t, tb, mirror)
+
+	for i := range 3 {
+		tb.send(t, fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/list"}`, i+1))
+	}
+	tb.waitLines(t, 3)
+}
+
+func TestBridgeMirrorCloseDuringDelivery(t *testing.T) {
+	srv := startMockSSEServer(t)
+	tb := newTestBridgeWithClient(t, newTestConfig(srv.URL+"/sse"), &http.Client{}, bridge.TransportSSE)
+	mirror := bridge.NewMirror(io.Discard, logging.NewWithWriter(logging.LogLevelError, io.Discard))
+	tb.SetMirror(mirror)
+	tb.start()
+	tb.send(t, `{"jsonrpc":"2.0","id":0,"method":"ping"}`)
+	tb.waitLines(t, 1)
+
+	// Messages still arriving after Close are ignored rather than panicking
+	go func() {
+		for i := range 200 {
+			srv.Push(map[string]any{"jsonrpc": "2.0", "method": "notifications/progress", "params": map[string]any{"n": i}})
+		}
+	}()
+	if err := mirror.Close(context.Background()); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	tb.waitLines(t, 201)
+}
+
+func TestBridgeMirrorCloseIsBounded(t *testing.T) {
+	// Nobody reads the other end, so writes to the sink stall
+	sink, peer := net.Pipe()
+	defer peer.Close()
+	defer sink.Close()
+	mirror := bridge.NewMirror(sink, logging.NewWithWriter(logging.LogLevelError, io.Discard))
+
+	srv := startMockMCPServer(t)
+	tb := newTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+	tb.SetMirror(mirror)
+	tb.start()
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	tb.waitLines(t, 1)
+	tb.stop(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := mirror.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Close() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
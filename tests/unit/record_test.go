@@ -0,0 +1,92 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+func TestBridgeRecordAndReplay(t *testing.T) {
+	srv := startMockMCPServer(t)
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+
+	// Record a short session
+	var recording bytes.Buffer
+	recorder, err := bridge.NewRecorder(&recording, srv.URL+"/mcp?token=secret", bridge.TransportStreamable)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	stdinR, stdinW := io.Pipe()
+	stdout := &syncBuffer{}
+	b := bridge.NewWithIO(newTestConfig(srv.URL+"/mcp"), &http.Client{}, logger, bridge.TransportStreamable, stdinR, stdout)
+	b.SetRecorder(recorder)
+	done := make(chan error, 1)
+	go func() { done <- b.Run(context.Background()) }()
+
+	io.WriteString(stdinW, `{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{"cursor":"a"}}`+"\n")
+	waitForLines(t, stdout, 1)
+	time.Sleep(100 * time.Millisecond)
+	io.WriteString(stdinW, `{"jsonrpc":"2.0","id":2,"method":"tools/list","params":{"cursor":"b"}}`+"\n")
+	waitForLines(t, stdout, 2)
+	stdinW.Close()
+	<-done
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("recording error = %v", err)
+	}
+
+	rec, err := bridge.ReadRecording(&recording)
+	if err != nil {
+		t.Fatalf("ReadRecording() error = %v", err)
+	}
+	if rec.Header.Server != srv.URL+"/mcp" || rec.Header.Transport != bridge.TransportStreamable {
+		t.Errorf("header = %+v", rec.Header)
+	}
+	kinds := []string{bridge.RecordRequest, bridge.RecordResponse, bridge.RecordRequest, bridge.RecordResponse}
+	if len(rec.Events) != len(kinds) {
+		t.Fatalf("recorded %d events, want %d", len(rec.Events), len(kinds))
+	}
+	for i, kind := range kinds {
+		if rec.Events[i].Kind != kind {
+			t.Errorf("event %d kind = %s, want %s", i, rec.Events[i].Kind, kind)
+		}
+	}
+	gap := rec.Events[2].Offset - rec.Events[0].Offset
+	if gap < 100*time.Millisecond {
+		t.Errorf("requests recorded %v apart, want at least 100ms", gap)
+	}
+
+	// Replay it against the server
+	replayed := &syncBuffer{}
+	start := time.Now()
+	b = bridge.NewWithIO(newTestConfig(srv.URL+"/mcp"), &http.Client{}, logger, bridge.TransportStreamable, bridge.NewReplayReader(rec), replayed)
+	if err := b.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < gap {
+		t.Errorf("replay took %v, want at least the recorded %v", elapsed, gap)
+	}
+	if got, want := replayed.String(), stdout.String(); got != want {
+		t.Errorf("replayed output = %q, want %q", got, want)
+	}
+	if n := len(srv.Messages()); n != 4 {
+		t.Errorf("server received %d messages, want 4", n)
+	}
+}
+
+// waitForLines waits until buf holds at least n lines.
+func waitForLines(t *testing.T, buf *syncBuffer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(buf.Lines()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d lines, got %q", n, buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,186 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/config"
+	"github.com/iiharu/mcp-over-socks/internal/transport"
+)
+
+func TestMatchHostPattern(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"api.example.com", "api.example.com", true},
+		{"api.example.com", "API.Example.com", true},
+		{"api.example.com", "www.example.com", false},
+		{"*.internal", "svc.internal", true},
+		{"*.internal", "a.b.internal", true},
+		{"*.internal", "internal", false},
+		{"*.internal", "notinternal", false},
+		{"*", "anything.example", true},
+	}
+	for _, tt := range tests {
+		if got := transport.MatchHostPattern(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("MatchHostPattern(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestRuleBasedDialer(t *testing.T) {
+	proxyA := startFakeSOCKSProxy(t)
+	proxyB := startFakeSOCKSProxy(t)
+	target := startEchoTarget(t, "hello")
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+
+	newDialer := func(addr string) *transport.SOCKSDialer {
+		d, err := transport.NewSOCKSDialer(addr, nil, true)
+		if err != nil {
+			t.Fatalf("NewSOCKSDialer() error = %v", err)
+		}
+		// The fake proxies only connect to IP addresses
+		d.SetResolveOverrides(map[string]string{
+			net.JoinHostPort("svc.internal", port):    "127.0.0.1",
+			net.JoinHostPort("api.partner.com", port): "127.0.0.1",
+		})
+		return d
+	}
+	a, b := newDialer(proxyA.Addr()), newDialer(proxyB.Addr())
+	rules := transport.NewRuleBasedDialer([]transport.ProxyRoute{
+		{Pattern: "*.internal", Dialer: a},
+		{Pattern: "127.0.0.1", Dialer: nil},
+	}, b)
+
+	tests := []struct {
+		name  string
+		addr  string
+		route *transport.SOCKSDialer
+	}{
+		{"matching", net.JoinHostPort("svc.internal", port), a},
+		{"default", net.JoinHostPort("api.partner.com", port), b},
+		{"direct", net.JoinHostPort("127.0.0.1", port), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Route(tt.addr); got != tt.route {
+				t.Errorf("Route(%q) picked the wrong dialer", tt.addr)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := rules.DialContext(ctx, "tcp", tt.addr)
+			if err != nil {
+				t.Fatalf("DialContext() error = %v", err)
+			}
+			got, _ := io.ReadAll(conn)
+			conn.Close()
+			if string(got) != "hello" {
+				t.Errorf("read %q, want %q", got, "hello")
+			}
+		})
+	}
+
+	// Each proxy saw only its own destination; the direct dial used neither
+	if got := proxyA.Targets(); len(got) != 1 {
+		t.Errorf("proxy A targets = %v, want one", got)
+	}
+	if got := proxyB.Targets(); len(got) != 1 {
+		t.Errorf("proxy B targets = %v, want one", got)
+	}
+}
+
+func TestRuleBasedDialerDirectSettings(t *testing.T) {
+	// The target accepts connections but never writes
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	rules := transport.NewRuleBasedDialer([]transport.ProxyRoute{{Pattern: "*"}}, nil)
+	rules.SetResolveOverrides(map[string]string{net.JoinHostPort("svc.invalid", port): "127.0.0.1"})
+	rules.SetSocketIOTimeout(50 * time.Millisecond)
+	rules.SetDialLimiter(transport.NewDialLimiter(1))
+
+	// --resolve pins the unresolvable host, and --socket-io-timeout bounds
+	// the read
+	conn, err := rules.DialContext(context.Background(), "tcp", net.JoinHostPort("svc.invalid", port))
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer conn.Close()
+	var netErr net.Error
+	if _, err := conn.Read(make([]byte, 1)); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Read() error = %v, want a timeout", err)
+	}
+
+	// The limit applies to direct dials too
+	limited := transport.NewRuleBasedDialer([]transport.ProxyRoute{{Pattern: "*"}}, nil)
+	limiter := transport.NewDialLimiter(1)
+	limited.SetDialLimiter(limiter)
+	blocker := transport.NewSOCKSDialerFromDialer(&countingDialer{delay: 200 * time.Millisecond}, true)
+	blocker.SetDialLimiter(limiter)
+	go blocker.DialContext(context.Background(), "tcp", "example.invalid:80")
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limited.DialContext(ctx, "tcp", ln.Addr().String()); err != context.DeadlineExceeded {
+		t.Errorf("DialContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConfigLoadProxyRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules")
+	os.WriteFile(path, []byte(`# routes
+default -> direct
+*.internal -> socks5h://a:1080
+*.partner.com -> socks5://user:pass@b:1080
+`), 0o600)
+
+	cfg := &config.Config{ProxyRulesFile: path}
+	rules, err := cfg.LoadProxyRules()
+	if err != nil {
+		t.Fatalf("LoadProxyRules() error = %v", err)
+	}
+	want := []config.ProxyRule{
+		{Pattern: "*.internal", ProxyAddr: "socks5h://a:1080"},
+		{Pattern: "*.partner.com", ProxyAddr: "socks5://user:pass@b:1080"},
+		{Pattern: "*", ProxyAddr: ""},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("LoadProxyRules() = %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+
+	os.WriteFile(path, []byte("*.internal -> http://a:8080\n"), 0o600)
+	if _, err := cfg.LoadProxyRules(); err == nil || !contains(err.Error(), "line 1") {
+		t.Errorf("LoadProxyRules() error = %v, want an error for line 1", err)
+	}
+}
@@ -0,0 +1,284 @@
+package unit
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSOCKSProxy is a minimal SOCKS5 proxy (RFC 1928) for tests.
+// It supports the no-auth and username/password methods and the CONNECT and
+// BIND commands, and records every requested destination.
+type fakeSOCKSProxy struct {
+	ln net.Listener
+
+	// Username and Password, if set, require username/password authentication.
+	Username string
+	Password string
+
+	// ConnectReply, if nonzero, refuses CONNECT requests with this reply code.
+	ConnectReply byte
+
+	// DropPOST closes a tunnel as soon as the client starts an HTTP POST on it.
+	DropPOST atomic.Bool
+
+	mu      sync.Mutex
+	targets []string
+}
+
+// startFakeSOCKSProxy starts a fake SOCKS5 proxy on a random local port.
+func startFakeSOCKSProxy(t *testing.T) *fakeSOCKSProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return serveFakeSOCKSProxy(t, ln)
+}
+
+// startFakeTLSSOCKSProxy starts a fake SOCKS5 proxy wrapped in TLS
+// (stunnel-style) and returns it with a cert pool that trusts it.
+func startFakeTLSSOCKSProxy(t *testing.T) (*fakeSOCKSProxy, *x509.CertPool) {
+	t.Helper()
+	cert, pool := newTestCertificate(t, "proxy.test")
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return serveFakeSOCKSProxy(t, ln), pool
+}
+
+// serveFakeSOCKSProxy serves a fake SOCKS5 proxy on ln.
+func serveFakeSOCKSProxy(t *testing.T, ln net.Listener) *fakeSOCKSProxy {
+	p := &fakeSOCKSProxy{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return p
+}
+
+// Addr returns the proxy's "host:port" address.
+func (p *fakeSOCKSProxy) Addr() string {
+	return p.ln.Addr().String()
+}
+
+// Targets returns the destinations requested so far.
+func (p *fakeSOCKSProxy) Targets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func (p *fakeSOCKSProxy) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// Method negotiation
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	switch {
+	case p.Username == "":
+		conn.Write([]byte{0x05, 0x00})
+	case bytes.IndexByte(methods, 0x02) >= 0:
+		conn.Write([]byte{0x05, 0x02})
+		if !p.authenticate(conn) {
+			return
+		}
+	default:
+		conn.Write([]byte{0x05, 0xff})
+		return
+	}
+
+	// Request
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	host, ok := readSOCKSAddr(conn, req[3])
+	if !ok {
+		return
+	}
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBuf); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBuf))))
+
+	p.mu.Lock()
+	p.targets = append(p.targets, target)
+	p.mu.Unlock()
+
+	if req[1] == 0x02 {
+		p.bind(conn)
+		return
+	}
+
+	if p.ConnectReply != 0 {
+		conn.Write([]byte{0x05, p.ConnectReply, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+	if p.DropPOST.Load() {
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil || bytes.HasPrefix(buf[:n], []byte("POST ")) {
+			return
+		}
+		upstream.Write(buf[:n])
+	}
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+// bind serves a BIND request: it listens on a random local port, reports it,
+// then reports and relays the first inbound connection.
+func (p *fakeSOCKSProxy) bind(conn net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer ln.Close()
+	conn.Write(socksReply(ln.Addr().(*net.TCPAddr)))
+
+	peer, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer peer.Close()
+	conn.Write(socksReply(peer.RemoteAddr().(*net.TCPAddr)))
+
+	go io.Copy(peer, conn)
+	io.Copy(conn, peer)
+}
+
+// socksReply builds a successful SOCKS5 reply carrying an IPv4 address.
+func socksReply(addr *net.TCPAddr) []byte {
+	reply := []byte{0x05, 0x00, 0x00, 0x01}
+	reply = append(reply, addr.IP.To4()...)
+	return binary.BigEndian.AppendUint16(reply, uint16(addr.Port))
+}
+
+// authenticate performs the username/password sub-negotiation (RFC 1929).
+func (p *fakeSOCKSProxy) authenticate(conn net.Conn) bool {
+	ver := make([]byte, 2)
+	if _, err := io.ReadFull(conn, ver); err != nil {
+		return false
+	}
+	user := make([]byte, ver[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return false
+	}
+	plen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, plen); err != nil {
+		return false
+	}
+	pass := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return false
+	}
+	if string(user) != p.Username || string(pass) != p.Password {
+		conn.Write([]byte{0x01, 0x01})
+		return false
+	}
+	conn.Write([]byte{0x01, 0x00})
+	return true
+}
+
+// readSOCKSAddr reads a SOCKS5 address of the given type.
+func readSOCKSAddr(r io.Reader, atyp byte) (string, bool) {
+	switch atyp {
+	case 0x01:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", false
+		}
+		return net.IP(ip).String(), true
+	case 0x04:
+		ip := make([]byte, 16)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", false
+		}
+		return net.IP(ip).String(), true
+	case 0x03:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(r, n); err != nil {
+			return "", false
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", false
+		}
+		return string(name), true
+	default:
+		return "", false
+	}
+}
+
+// newTestCertificate returns a self-signed certificate for host and a cert
+// pool that trusts it.
+func newTestCertificate(t *testing.T, host string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
@@ -0,0 +1,476 @@
+package unit
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/transport"
+	"golang.org/x/net/proxy"
+)
+
+// startEchoTarget starts a TCP listener that writes greeting to each connection.
+func startEchoTarget(t *testing.T, greeting string) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(greeting))
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+func TestSOCKSDialerResolveOverride(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	target := startEchoTarget(t, "pinned")
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+
+	for _, remoteDNS := range []bool{false, true} {
+		d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, remoteDNS)
+		if err != nil {
+			t.Fatalf("NewSOCKSDialer() error = %v", err)
+		}
+		d.SetResolveOverrides(map[string]string{
+			net.JoinHostPort("blue.example.invalid", port): "127.0.0.1",
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("blue.example.invalid", port))
+		cancel()
+		if err != nil {
+			t.Fatalf("DialContext() error = %v", err)
+		}
+		got, _ := io.ReadAll(conn)
+		conn.Close()
+		if string(got) != "pinned" {
+			t.Errorf("read %q, want %q", got, "pinned")
+		}
+	}
+
+	want := net.JoinHostPort("127.0.0.1", port)
+	for _, got := range proxySrv.Targets() {
+		if got != want {
+			t.Errorf("proxy target = %q, want pinned %q", got, want)
+		}
+	}
+}
+
+func TestSOCKSDialerAuthentication(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	proxySrv.Username = "user"
+	proxySrv.Password = "secret"
+	target := startEchoTarget(t, "authenticated")
+
+	tests := []struct {
+		name    string
+		auth    *proxy.Auth
+		wantErr error
+	}{
+		{"valid credentials", &proxy.Auth{User: "user", Password: "secret"}, nil},
+		{"rejected credentials", &proxy.Auth{User: "user", Password: "wrong"}, transport.ErrProxyAuthRejected},
+		{"missing credentials", nil, transport.ErrProxyAuthRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := transport.NewSOCKSDialer(proxySrv.Addr(), tt.auth, false)
+			if err != nil {
+				t.Fatalf("NewSOCKSDialer() error = %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := d.DialContext(ctx, "tcp", target.Addr().String())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DialContext() error = %v, want %v", err, tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr.Error()) {
+					t.Errorf("error message %q does not explain the failure", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DialContext() error = %v", err)
+			}
+			got, _ := io.ReadAll(conn)
+			conn.Close()
+			if string(got) != "authenticated" {
+				t.Errorf("read %q, want %q", got, "authenticated")
+			}
+		})
+	}
+}
+
+func TestSOCKSDialerProxyPolicyRejection(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	proxySrv.ConnectReply = 0x02
+
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, true)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = d.DialContext(ctx, "tcp", "internal.example.com:443")
+	if !errors.Is(err, transport.ErrProxyPolicy) {
+		t.Fatalf("DialContext() error = %v, want ErrProxyPolicy", err)
+	}
+	if !strings.Contains(err.Error(), "Proxy policy forbids connecting to internal.example.com") ||
+		!strings.Contains(err.Error(), "allowlist") {
+		t.Errorf("error message %q is not the policy explanation", err)
+	}
+}
+
+func TestSOCKSDialerBind(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	proxySrv.Username = "user"
+	proxySrv.Password = "secret"
+
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), &proxy.Auth{User: "user", Password: "secret"}, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bind, err := d.Bind(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	defer bind.Close()
+
+	// The remote peer connects back to the address the proxy is listening on
+	peer, err := net.Dial("tcp", bind.BoundAddr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to bound address %s: %v", bind.BoundAddr(), err)
+	}
+	defer peer.Close()
+
+	conn, err := bind.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != peer.LocalAddr().String() {
+		t.Errorf("RemoteAddr() = %s, want peer %s", conn.RemoteAddr(), peer.LocalAddr())
+	}
+
+	peer.Write([]byte("callback"))
+	got := make([]byte, len("callback"))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if string(got) != "callback" {
+		t.Errorf("read %q, want %q", got, "callback")
+	}
+}
+
+func TestSOCKSDialerBindAcceptCancelled(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+
+	bind, err := d.Bind(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := bind.Accept(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Accept() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSOCKSDialerSocketIOTimeout(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	// A target that accepts connections but never sends anything
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { stalled.Close() })
+	go func() {
+		for {
+			conn, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	d.SetSocketIOTimeout(100 * time.Millisecond)
+
+	dials := map[string]func() (net.Conn, error){
+		"DialContext": func() (net.Conn, error) {
+			return d.DialContext(context.Background(), "tcp", stalled.Addr().String())
+		},
+		"Dial": func() (net.Conn, error) {
+			return d.Dial("tcp", stalled.Addr().String())
+		},
+	}
+	for name, dial := range dials {
+		t.Run(name, func(t *testing.T) {
+			conn, err := dial()
+			if err != nil {
+				t.Fatalf("%s() error = %v", name, err)
+			}
+			defer conn.Close()
+
+			start := time.Now()
+			_, err = conn.Read(make([]byte, 1))
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Fatalf("Read() error = %v, want a timeout", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Read() took %v to time out", elapsed)
+			}
+		})
+	}
+}
+
+func TestSOCKSDialerOverTLS(t *testing.T) {
+	proxySrv, pool := startFakeTLSSOCKSProxy(t)
+	target := startEchoTarget(t, "over tls")
+
+	t.Run("trusted proxy", func(t *testing.T) {
+		d, err := transport.NewSOCKSDialerOverTLS(proxySrv.Addr(), nil, false, &tls.Config{
+			RootCAs:    pool,
+			ServerName: "proxy.test",
+		})
+		if err != nil {
+			t.Fatalf("NewSOCKSDialerOverTLS() error = %v", err)
+		}
+		conn, err := d.DialContext(context.Background(), "tcp", target.Addr().String())
+		if err != nil {
+			t.Fatalf("DialContext() error = %v", err)
+		}
+		got, _ := io.ReadAll(conn)
+		conn.Close()
+		if string(got) != "over tls" {
+			t.Errorf("read %q, want %q", got, "over tls")
+		}
+	})
+
+	t.Run("untrusted proxy", func(t *testing.T) {
+		d, err := transport.NewSOCKSDialerOverTLS(proxySrv.Addr(), nil, false, &tls.Config{ServerName: "proxy.test"})
+		if err != nil {
+			t.Fatalf("NewSOCKSDialerOverTLS() error = %v", err)
+		}
+		conn, err := d.DialContext(context.Background(), "tcp", target.Addr().String())
+		if err == nil {
+			conn.Close()
+			t.Fatal("expected TLS verification failure for an untrusted proxy")
+		}
+		if !strings.Contains(err.Error(), "TLS handshake with SOCKS proxy") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("unreachable proxy", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		addr := ln.Addr().String()
+		ln.Close()
+
+		d, err := transport.NewSOCKSDialerOverTLS(addr, nil, false, &tls.Config{ServerName: "proxy.test"})
+		if err != nil {
+			t.Fatalf("NewSOCKSDialerOverTLS() error = %v", err)
+		}
+		conn, err := d.DialContext(context.Background(), "tcp", target.Addr().String())
+		if err == nil {
+			conn.Close()
+			t.Fatal("expected a connection failure for an unreachable proxy")
+		}
+		if !strings.Contains(err.Error(), "Failed to connect to SOCKS proxy") || strings.Contains(err.Error(), "TLS handshake") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+// slowDialer is a proxy.Dialer without DialContext whose dials complete
+// only after release is closed.
+type slowDialer struct {
+	release chan struct{}
+	conns   chan *closeTrackingConn
+}
+
+func (d *slowDialer) Dial(network, addr string) (net.Conn, error) {
+	<-d.release
+	client, server := net.Pipe()
+	server.Close()
+	conn := &closeTrackingConn{Conn: client, closed: make(chan struct{})}
+	d.conns <- conn
+	return conn, nil
+}
+
+// closeTrackingConn reports when it is closed.
+type closeTrackingConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *closeTrackingConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestSOCKSDialerCancelledFallbackDialClosesConn(t *testing.T) {
+	slow := &slowDialer{release: make(chan struct{}), conns: make(chan *closeTrackingConn, 1)}
+	d := transport.NewSOCKSDialerFromDialer(slow, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := d.DialContext(ctx, "tcp", "example.invalid:80"); err != context.DeadlineExceeded {
+		t.Fatalf("DialContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Let the abandoned dial complete; its connection must be closed
+	close(slow.release)
+	conn := <-slow.conns
+	select {
+	case <-conn.closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection from the cancelled dial was not closed")
+	}
+}
+
+// countingDialer is a proxy.Dialer that records the peak number of dials in
+// progress at once. Each dial takes delay.
+type countingDialer struct {
+	delay time.Duration
+
+	mu           sync.Mutex
+	active, peak int
+}
+
+func (d *countingDialer) Dial(network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	d.active++
+	d.peak = max(d.peak, d.active)
+	d.mu.Unlock()
+
+	time.Sleep(d.delay)
+
+	d.mu.Lock()
+	d.active--
+	d.mu.Unlock()
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func TestSOCKSDialerMaxConcurrentDials(t *testing.T) {
+	counting := &countingDialer{delay: 50 * time.Millisecond}
+	d := transport.NewSOCKSDialerFromDialer(counting, true)
+	d.SetMaxConcurrentDials(1)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := d.DialContext(context.Background(), "tcp", "example.invalid:80")
+			if err != nil {
+				t.Errorf("DialContext() error = %v", err)
+				return
+			}
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+	if counting.peak != 1 {
+		t.Errorf("peak concurrent dials = %d, want 1", counting.peak)
+	}
+
+	// A dial waiting for a slot gives up when its context is done
+	d.SetMaxConcurrentDials(1)
+	go d.DialContext(context.Background(), "tcp", "example.invalid:80")
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.DialContext(ctx, "tcp", "example.invalid:80"); err != context.DeadlineExceeded {
+		t.Errorf("DialContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSOCKSDialerAbandonedDialKeepsSlot(t *testing.T) {
+	counting := &countingDialer{delay: 200 * time.Millisecond}
+	d := transport.NewSOCKSDialerFromDialer(counting, true)
+	d.SetMaxConcurrentDials(1)
+
+	// The dial is abandoned, but keeps running until the delay is over
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.DialContext(ctx, "tcp", "example.invalid:80"); err != context.DeadlineExceeded {
+		t.Fatalf("DialContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// So the next dial must wait for it rather than overlap
+	conn, err := d.DialContext(context.Background(), "tcp", "example.invalid:80")
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	conn.Close()
+	if counting.peak != 1 {
+		t.Errorf("peak concurrent dials = %d, want 1", counting.peak)
+	}
+}
+
+func TestSOCKSDialerSharedDialLimiter(t *testing.T) {
+	counting := &countingDialer{delay: 50 * time.Millisecond}
+	limiter := transport.NewDialLimiter(1)
+	dialers := []*transport.SOCKSDialer{
+		transport.NewSOCKSDialerFromDialer(counting, true),
+		transport.NewSOCKSDialerFromDialer(counting, true),
+	}
+	for _, d := range dialers {
+		d.SetDialLimiter(limiter)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := dialers[i%2].DialContext(context.Background(), "tcp", "example.invalid:80")
+			if err != nil {
+				t.Errorf("DialContext() error = %v", err)
+				return
+			}
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+	if counting.peak != 1 {
+		t.Errorf("peak concurrent dials across dialers = %d, want 1", counting.peak)
+	}
+}
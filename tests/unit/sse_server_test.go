@@ -0,0 +1,166 @@
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/config"
+)
+
+// mockSSEServer is a minimal 2024-11-05 SSE MCP server.
+// GET /sse opens the event stream and announces /message as the POST endpoint.
+// Messages POSTed to /message are recorded and passed to OnMessage, whose
+// non-nil return value is pushed onto the stream; returning an httpStatus
+// fails the POST instead.
+type mockSSEServer struct {
+	*httptest.Server
+
+	// OnMessage computes the reply to a posted message, or nil for no reply.
+	OnMessage func(msg map[string]any) any
+
+	// EndpointDelay delays the endpoint event, simulating a slow connect.
+	EndpointDelay time.Duration
+
+	events      chan []byte
+	closeStream chan struct{}
+	closeOnce   sync.Once
+
+	mu       sync.Mutex
+	messages []map[string]any
+	streams  int
+}
+
+// startMockSSEServer starts a mock SSE MCP server that echoes requests' params.
+func startMockSSEServer(t *testing.T) *mockSSEServer {
+	t.Helper()
+	m := &mockSSEServer{
+		OnMessage: func(msg map[string]any) any {
+			if _, ok := msg["id"]; !ok || msg["method"] == nil {
+				return nil
+			}
+			return map[string]any{"jsonrpc": "2.0", "id": msg["id"], "result": msg["params"]}
+		},
+		events:      make(chan []byte, 100),
+		closeStream: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", m.serveStream)
+	mux.HandleFunc("POST /message", m.serveMessage)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		m.CloseStream()
+		m.Close()
+	})
+	return m
+}
+
+func (m *mockSSEServer) serveStream(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.streams++
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	time.Sleep(m.EndpointDelay)
+	fmt.Fprintf(w, "event: endpoint\ndata: /message\n\n")
+	w.(http.Flusher).Flush()
+
+	for {
+		select {
+		case frame := <-m.events:
+			w.Write(frame)
+			w.(http.Flusher).Flush()
+		case <-m.closeStream:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (m *mockSSEServer) serveMessage(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var msg map[string]any
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	m.messages = append(m.messages, msg)
+	onMessage := m.OnMessage
+	m.mu.Unlock()
+
+	switch reply := onMessage(msg).(type) {
+	case nil:
+		w.WriteHeader(http.StatusAccepted)
+	case httpStatus:
+		w.WriteHeader(int(reply))
+	default:
+		w.WriteHeader(http.StatusAccepted)
+		m.Push(reply)
+	}
+}
+
+// Push sends v as a message event on the stream.
+func (m *mockSSEServer) Push(v any) {
+	data, _ := json.Marshal(v)
+	m.PushRaw(string(data))
+}
+
+// PushRaw sends data verbatim as a message event on the stream.
+func (m *mockSSEServer) PushRaw(data string) {
+	m.PushFrame("event: message\ndata: " + data + "\n\n")
+}
+
+// PushFrame sends frame verbatim on the stream.
+func (m *mockSSEServer) PushFrame(frame string) {
+	m.events <- []byte(frame)
+}
+
+// CloseStream ends the event stream, as if the server went away.
+func (m *mockSSEServer) CloseStream() {
+	m.closeOnce.Do(func() { close(m.closeStream) })
+}
+
+// Messages returns the messages posted so far.
+func (m *mockSSEServer) Messages() []map[string]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]map[string]any(nil), m.messages...)
+}
+
+// Streams returns the number of event streams opened so far.
+func (m *mockSSEServer) Streams() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams
+}
+
+// startSSETestBridge runs an SSE bridge for cfg in the background.
+func startSSETestBridge(t *testing.T, cfg *config.Config) *testBridge {
+	t.Helper()
+	return startTestBridgeWithClient(t, cfg, &http.Client{}, bridge.TransportSSE)
+}
+
+// waitMessages waits until the server has received at least n messages.
+func (m *mockSSEServer) waitMessages(t *testing.T, n int) []map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if msgs := m.Messages(); len(msgs) >= n {
+			return msgs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d server messages, got %d", n, len(m.Messages()))
+	return nil
+}
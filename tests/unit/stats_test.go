@@ -0,0 +1,56 @@
+package unit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatsWriteFile(t *testing.T) {
+	srv := startMockMCPServer(t)
+	tb := startTestBridge(t, newTestConfig(srv.URL+"/mcp"))
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	tb.send(t, `not json`)
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+	tb.waitLines(t, 2)
+	if err := tb.stop(t); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	if err := tb.Stats().WriteFile(path); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	var got map[string]float64
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("metrics file is not valid JSON: %v\n%s", err, data)
+	}
+
+	for _, field := range []string{"messages_sent", "messages_received", "bytes_sent", "bytes_received", "errors", "duration_seconds"} {
+		if _, ok := got[field]; !ok {
+			t.Errorf("metrics file missing field %q", field)
+		}
+	}
+	if got["messages_sent"] != 2 {
+		t.Errorf("messages_sent = %v, want 2", got["messages_sent"])
+	}
+	if got["messages_received"] != 2 {
+		t.Errorf("messages_received = %v, want 2", got["messages_received"])
+	}
+	if got["errors"] != 1 {
+		t.Errorf("errors = %v, want 1", got["errors"])
+	}
+	if got["bytes_sent"] <= 0 || got["bytes_received"] <= 0 {
+		t.Errorf("expected non-zero byte counters, got %v", got)
+	}
+	if got["duration_seconds"] <= 0 {
+		t.Errorf("duration_seconds = %v, want > 0", got["duration_seconds"])
+	}
+}
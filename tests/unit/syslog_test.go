@@ -0,0 +1,47 @@
+//go:build !windows && !plan9
+
+package unit
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+func TestSyslogWriter(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	w, err := logging.NewSyslogWriter("udp://"+listener.LocalAddr().String(), "mcp-over-socks")
+	if err != nil {
+		t.Fatalf("NewSyslogWriter() error = %v", err)
+	}
+	defer w.Close()
+
+	logger := logging.NewWithWriter(logging.LogLevelDebug, w)
+	logger.Error("proxy unreachable")
+	logger.Warn("slow server")
+
+	// Priority is facility (user = 1) * 8 + severity
+	for _, want := range []string{"<11>", "<12>"} {
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1024)
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("failed to read syslog packet: %v", err)
+		}
+		packet := string(buf[:n])
+		if !strings.HasPrefix(packet, want) {
+			t.Errorf("syslog packet %q, want priority %s", packet, want)
+		}
+		if !strings.Contains(packet, "mcp-over-socks") {
+			t.Errorf("syslog packet %q missing tag", packet)
+		}
+	}
+}
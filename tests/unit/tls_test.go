@@ -0,0 +1,217 @@
+package unit
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/transport"
+)
+
+// startTLSServer starts an HTTPS server with the given TLS settings and
+// returns it with a cert pool that trusts it.
+func startTLSServer(t *testing.T, tlsConfig *tls.Config) (*httptest.Server, *x509.CertPool) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	return srv, pool
+}
+
+// newTLSClient returns an HTTP client dialing through proxyAddr with tlsConfig.
+func newTLSClient(t *testing.T, proxyAddr string, tlsConfig *tls.Config) *http.Client {
+	t.Helper()
+	d, err := transport.NewSOCKSDialer(proxyAddr, nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	d.SetTLSConfig(tlsConfig)
+	return d.HTTPClient(5 * time.Second)
+}
+
+func TestServerTLSMinVersion(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	srv, pool := startTLSServer(t, &tls.Config{MaxVersion: tls.VersionTLS12})
+
+	t.Run("handshake below minimum fails", func(t *testing.T) {
+		client := newTLSClient(t, proxySrv.Addr(), &tls.Config{MinVersion: tls.VersionTLS13, RootCAs: pool})
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+			t.Fatal("expected handshake failure with TLS 1.3 minimum against a TLS 1.2 server")
+		}
+		if !strings.Contains(err.Error(), "protocol version") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("handshake at minimum succeeds", func(t *testing.T) {
+		client := newTLSClient(t, proxySrv.Addr(), &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool})
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.TLS == nil || resp.TLS.Version != tls.VersionTLS12 {
+			t.Errorf("expected TLS 1.2 connection, got %+v", resp.TLS)
+		}
+	})
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	srv, pool := startTLSServer(t, &tls.Config{})
+
+	for _, enabled := range []bool{true, false} {
+		d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+		if err != nil {
+			t.Fatalf("NewSOCKSDialer() error = %v", err)
+		}
+		d.SetTLSConfig(&tls.Config{RootCAs: pool})
+		if enabled {
+			d.SetTLSSessionCache(tls.NewLRUClientSessionCache(0))
+		}
+		client := d.HTTPClient(5 * time.Second)
+
+		var resumed []bool
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			io.ReadAll(resp.Body)
+			resp.Body.Close()
+			resumed = append(resumed, resp.TLS.DidResume)
+			// Force a new connection, and so a new handshake
+			client.CloseIdleConnections()
+		}
+
+		if resumed[0] {
+			t.Errorf("cache %v: first handshake resumed a session", enabled)
+		}
+		if resumed[1] != enabled {
+			t.Errorf("cache %v: second handshake resumed = %v", enabled, resumed[1])
+		}
+	}
+}
+
+func TestServerCertificatePin(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	srv, pool := startTLSServer(t, &tls.Config{})
+	fingerprint := sha256.Sum256(srv.Certificate().Raw)
+	other := sha256.Sum256([]byte("rotated"))
+
+	tests := []struct {
+		name    string
+		pins    [][]byte
+		wantErr bool
+	}{
+		{"matching pin", [][]byte{fingerprint[:]}, false},
+		{"matching pin among several", [][]byte{other[:], fingerprint[:]}, false},
+		{"mismatched pin", [][]byte{other[:]}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTLSClient(t, proxySrv.Addr(), &tls.Config{
+				RootCAs:          pool,
+				VerifyConnection: transport.VerifyCertificatePins(tt.pins),
+			})
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "does not match any --server-pin") {
+					t.Errorf("expected pin mismatch error, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("request failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadCertPoolDir(t *testing.T) {
+	proxySrv := startFakeSOCKSProxy(t)
+	serverCert, _ := newTestCertificate(t, "127.0.0.1")
+	otherCert, _ := newTestCertificate(t, "other.test")
+
+	dir := t.TempDir()
+	writePEM := func(name string, cert tls.Certificate) {
+		data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePEM("other.pem", otherCert)
+	writePEM("internal.crt", serverCert)
+	os.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0o644)
+
+	pool, err := transport.LoadCertPoolDir(dir)
+	if err != nil {
+		t.Fatalf("LoadCertPoolDir() error = %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := newTLSClient(t, proxySrv.Addr(), &tls.Config{RootCAs: pool})
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if _, err := transport.LoadCertPoolDir(t.TempDir()); err == nil || !strings.Contains(err.Error(), "no *.pem or *.crt CA files") {
+		t.Errorf("LoadCertPoolDir(empty) error = %v", err)
+	}
+	if _, err := transport.LoadCertPoolDir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("LoadCertPoolDir(missing) expected error")
+	}
+}
+
+func TestBridgeLogsTLSDetails(t *testing.T) {
+	srv := &mockMCPServer{Respond: func(method string, params json.RawMessage) any { return params }}
+	srv.Server = httptest.NewTLSServer(http.HandlerFunc(srv.serveHTTP))
+	t.Cleanup(srv.Close)
+	client := srv.Client()
+	client.Timeout = 5 * time.Second
+
+	tb := startTestBridgeWithClient(t, newTestConfig(srv.URL+"/mcp"), client, bridge.TransportStreamable)
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	tb.waitLines(t, 2)
+
+	logs := tb.logs.String()
+	if !strings.Contains(logs, "TLS connection to "+srv.Listener.Addr().String()+": TLS 1.3, cipher TLS_") {
+		t.Errorf("expected TLS version and cipher in debug logs, got: %s", logs)
+	}
+	if !strings.Contains(logs, "peer O=Acme Co") {
+		t.Errorf("expected peer certificate subject in debug logs, got: %s", logs)
+	}
+	if n := strings.Count(logs, "TLS connection to "); n != 1 {
+		t.Errorf("TLS details logged %d times, want once per connection", n)
+	}
+}
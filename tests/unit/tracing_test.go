@@ -0,0 +1,99 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/tracing"
+	"github.com/iiharu/mcp-over-socks/internal/transport"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useInMemoryTracing installs a global tracer provider that records spans in
+// memory for the duration of the test.
+func useInMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return exporter
+}
+
+func TestBridgeTracesRequests(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+	srv := startMockMCPServer(t)
+	proxySrv := startFakeSOCKSProxy(t)
+	d, err := transport.NewSOCKSDialer(proxySrv.Addr(), nil, false)
+	if err != nil {
+		t.Fatalf("NewSOCKSDialer() error = %v", err)
+	}
+	client := d.HTTPClient(5 * time.Second)
+	client.Transport = tracing.NewTransport(client.Transport)
+	tb := startTestBridgeWithClient(t, newTestConfig(srv.URL+"/mcp"), client, bridge.TransportStreamable)
+
+	tb.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	tb.send(t, `{"jsonrpc":"2.0","id":2,"method":"resources/list"}`)
+	tb.waitLines(t, 2)
+
+	var spans tracetest.SpanStubs
+	deadline := time.Now().Add(5 * time.Second)
+	for len(spans) < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for spans, got %d", len(spans))
+		}
+		time.Sleep(10 * time.Millisecond)
+		spans = exporter.GetSpans()
+	}
+
+	byID := map[trace.SpanID]tracetest.SpanStub{}
+	for _, s := range spans {
+		byID[s.SpanContext.SpanID()] = s
+	}
+	parentName := func(s tracetest.SpanStub) string {
+		return byID[s.Parent.SpanID()].Name
+	}
+	requests, dials := map[string]bool{}, 0
+	for _, s := range spans {
+		switch s.Name {
+		case "HTTP POST":
+			// The HTTP round trip is a child of the request span
+			requests[parentName(s)] = true
+		case "SOCKS dial":
+			dials++
+			if parentName(s) != "HTTP POST" {
+				t.Errorf("SOCKS dial span parent = %q, want HTTP POST", parentName(s))
+			}
+		}
+	}
+	for _, method := range []string{"tools/list", "resources/list"} {
+		if !requests[method] {
+			t.Errorf("no request span with an HTTP child for %s", method)
+		}
+	}
+	if dials == 0 {
+		t.Error("no SOCKS dial span recorded")
+	}
+
+	for _, h := range srv.Headers() {
+		if h.Get("Traceparent") == "" {
+			t.Errorf("server request without traceparent header: %v", h)
+		}
+	}
+}
+
+func TestTracingSetupRejectsInvalidEndpoint(t *testing.T) {
+	if _, err := tracing.Setup(t.Context(), "localhost:4318", "test"); err == nil {
+		t.Error("Setup() expected error for endpoint without scheme")
+	}
+}
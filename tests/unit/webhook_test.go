@@ -0,0 +1,113 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iiharu/mcp-over-socks/internal/bridge"
+	"github.com/iiharu/mcp-over-socks/internal/logging"
+)
+
+// startMockWebhook starts a webhook that records posted events. The first
+// failures requests are answered with 503.
+func startMockWebhook(t *testing.T, failures int) (*httptest.Server, func() []bridge.Event) {
+	t.Helper()
+	var (
+		mu     sync.Mutex
+		events []bridge.Event
+		posts  int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		posts++
+		if posts <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e bridge.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		events = append(events, e)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []bridge.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]bridge.Event(nil), events...)
+	}
+}
+
+func TestBridgeSendsLifecycleEventsToWebhook(t *testing.T) {
+	srv := startMockMCPServer(t)
+	hook, events := startMockWebhook(t, 1)
+
+	serverURL := strings.Replace(srv.URL, "http://", "http://user:secret@", 1) + "/mcp?token=secret"
+	cfg := newTestConfig(serverURL)
+	stdinR, stdinW := io.Pipe()
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+	notifier := bridge.NewWebhookNotifier(hook.URL, &http.Client{}, logger)
+	b := bridge.NewWithIO(cfg, &http.Client{}, logger, bridge.TransportStreamable, stdinR, io.Discard)
+	b.SetEventHandler(notifier.Notify)
+
+	done := make(chan error, 1)
+	go func() { done <- b.Run(context.Background()) }()
+	io.WriteString(stdinW, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`+"\n")
+	stdinW.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bridge did not stop after stdin EOF")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := notifier.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	got := events()
+	if len(got) != 2 || got[0].Type != bridge.EventConnected || got[1].Type != bridge.EventDisconnected {
+		t.Fatalf("webhook received %+v, want connected then disconnected", got)
+	}
+	for _, e := range got {
+		if e.Server != srv.URL+"/mcp" {
+			t.Errorf("%s event server = %q, want %q", e.Type, e.Server, srv.URL+"/mcp")
+		}
+		if e.Time.IsZero() {
+			t.Errorf("%s event has no time", e.Type)
+		}
+	}
+}
+
+func TestBridgeSendsErrorEventToWebhook(t *testing.T) {
+	hook, events := startMockWebhook(t, 0)
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	logger := logging.NewWithWriter(logging.LogLevelError, io.Discard)
+	notifier := bridge.NewWebhookNotifier(hook.URL, &http.Client{}, logger)
+	b := bridge.NewWithIO(newTestConfig(dead.URL+"/sse"), &http.Client{}, logger, bridge.TransportSSE, strings.NewReader(""), io.Discard)
+	b.SetEventHandler(notifier.Notify)
+
+	if err := b.Run(context.Background()); err == nil {
+		t.Fatal("Run() succeeded against a closed server")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	notifier.Close(ctx)
+
+	got := events()
+	if len(got) != 1 || got[0].Type != bridge.EventError || got[0].Error == "" {
+		t.Fatalf("webhook received %+v, want one error event with a message", got)
+	}
+}